@@ -0,0 +1,215 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+)
+
+// 批量写入时还没有计算Hash的节点使用的临时key的前缀,临时key的长度和Hash不同,不会和Hash冲突
+var dirtyKeyPrefix = []byte("dirty:")
+
+/*
+*批量写入时的内存缓存
+*
+*StartBatch之后,新生成的节点不编码也不计算Hash,以临时key保存在内存中,父节点通过临时key引用它们
+*Hash或Commit时从Root开始自底向上计算改变过的节点的Hash,每个节点只编码和计算一次Hash
+*Commit时只把新的Root能访问到的节点写入数据库,中间过程中产生的已经被替换掉的节点不会写入数据库
+ */
+type writeBatch struct {
+	locker sync.RWMutex
+	seq    uint64
+	dirty  map[string]*TrieNode // 临时key -> 还没有计算Hash的节点
+	hashes map[string][]byte    // 临时key -> 已经计算出的Hash
+	data   map[string][]byte    // Hash -> 编码之后的节点或者Value
+	nodes  map[string]*TrieNode // Hash -> 已经计算过Hash的节点
+}
+
+func newWriteBatch() *writeBatch {
+	return &writeBatch{
+		locker: sync.RWMutex{},
+		dirty:  make(map[string]*TrieNode),
+		hashes: make(map[string][]byte),
+		data:   make(map[string][]byte),
+		nodes:  make(map[string]*TrieNode),
+	}
+}
+
+func isDirtyKey(key []byte) bool {
+	return len(key) == len(dirtyKeyPrefix)+8 && bytes.HasPrefix(key, dirtyKeyPrefix)
+}
+
+func (batch *writeBatch) get(hash []byte) ([]byte, bool) {
+	if batch == nil {
+		return nil, false
+	}
+	batch.locker.RLock()
+	defer batch.locker.RUnlock()
+	data, exist := batch.data[hex.EncodeToString(hash)]
+	return data, exist
+}
+
+func (batch *writeBatch) getNode(hash []byte) *TrieNode {
+	if batch == nil {
+		return nil
+	}
+	batch.locker.RLock()
+	defer batch.locker.RUnlock()
+	key := hex.EncodeToString(hash)
+	node, exist := batch.dirty[key]
+	if !exist {
+		node, exist = batch.nodes[key]
+	}
+	if !exist {
+		return nil
+	}
+	return node.copy()
+}
+
+func (batch *writeBatch) set(hash, data []byte) {
+	batch.locker.Lock()
+	defer batch.locker.Unlock()
+	batch.data[hex.EncodeToString(hash)] = data
+}
+
+// 保存一个还没有计算Hash的节点,返回它的临时key
+func (batch *writeBatch) setDirty(node *TrieNode) []byte {
+	batch.locker.Lock()
+	defer batch.locker.Unlock()
+	batch.seq++
+	key := make([]byte, len(dirtyKeyPrefix)+8)
+	copy(key, dirtyKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(dirtyKeyPrefix):], batch.seq)
+	batch.dirty[hex.EncodeToString(key)] = node.copy()
+	return key
+}
+
+// 计算key对应的节点的Hash,先计算子节点的Hash,已经计算过的节点直接返回之前的结果
+func (batch *writeBatch) hash(key []byte, encoding Encoding) ([]byte, error) {
+	if batch == nil || !isDirtyKey(key) {
+		return key, nil
+	}
+	batch.locker.Lock()
+	defer batch.locker.Unlock()
+	return batch.hashLocked(key, encoding)
+}
+
+func (batch *writeBatch) hashLocked(key []byte, encoding Encoding) ([]byte, error) {
+	if !isDirtyKey(key) {
+		return key, nil
+	}
+	_key := hex.EncodeToString(key)
+	if hash, exist := batch.hashes[_key]; exist {
+		return hash, nil
+	}
+	dirty, exist := batch.dirty[_key]
+	if !exist {
+		return nil, errors.New("Missing node")
+	}
+	node := dirty.copy()
+	for i, son := range node.Sons {
+		hash, err := batch.hashLocked(son.Hash, encoding)
+		if err != nil {
+			return nil, err
+		}
+		node.Sons[i].Hash = hash
+	}
+	data, err := EncodeNode(*node, encoding)
+	if err != nil {
+		return nil, err
+	}
+	hash := crypto.Sha3_256(data)
+	_hash := hex.EncodeToString(hash)
+	batch.hashes[_key] = hash
+	batch.data[_hash] = data
+	batch.nodes[_hash] = node
+	return hash, nil
+}
+
+func (node *TrieNode) copy() *TrieNode {
+	_node := *node
+	if node.Sons != nil {
+		_node.Sons = make(SortedSon, len(node.Sons))
+		copy(_node.Sons, node.Sons)
+	}
+	return &_node
+}
+
+// 开始批量写入,之后对树的修改在Commit之前都不会写入数据库
+func (this *MTP) StartBatch() {
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+	if this.getBatch() == nil {
+		this.setBatch(newWriteBatch())
+	}
+}
+
+func (this *MTP) getBatch() *writeBatch {
+	this.batchLock.RLock()
+	defer this.batchLock.RUnlock()
+	return this.batch
+}
+
+// 调用方需要持有this.Lock,batchLock只保护不持有this.Lock的读取
+func (this *MTP) setBatch(batch *writeBatch) {
+	this.batchLock.Lock()
+	defer this.batchLock.Unlock()
+	this.batch = batch
+}
+
+// 计算所有改变过的节点的Hash并更新Root,返回新的Root,不写入数据库
+func (this *MTP) Hash() (common.HexBytes, error) {
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+	root, err := this.getBatch().hash(this.Root, this.Encoding)
+	if err != nil {
+		return this.Root, err
+	}
+	this.Root = root
+	return root, nil
+}
+
+// 计算改变过的节点的Hash,把当前Root能访问到的新节点一次性写入数据库,并结束批量写入,之前的快照都会失效
+func (this *MTP) Commit() error {
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+	writeBatch := this.getBatch()
+	if writeBatch == nil {
+		this.journal = nil
+		return nil
+	}
+	root, err := writeBatch.hash(this.Root, this.Encoding)
+	if err != nil {
+		return err
+	}
+	batch, hashes := this.DB.NewBatch(), make([][]byte, 0)
+	writeBatch.flush(root, func(hash, data []byte) {
+		batch.Put(hash, data)
+		hashes = append(hashes, hash)
+	})
+	if err = writeNodes(hashes, func() error { return this.DB.WriteBatch(batch) }); err != nil {
+		return err
+	}
+	this.Root, this.journal = root, nil
+	this.setBatch(nil)
+	return nil
+}
+
+func (batch *writeBatch) flush(hash []byte, put func(hash, data []byte)) {
+	data, exist := batch.get(hash)
+	if !exist {
+		// 不在缓存中的节点已经在数据库中了,它的子节点也一定在数据库中
+		return
+	}
+	put(hash, data)
+	if node := batch.getNode(hash); node != nil {
+		for _, son := range node.Sons {
+			batch.flush(son.Hash, put)
+		}
+	}
+}
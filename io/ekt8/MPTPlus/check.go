@@ -0,0 +1,100 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+// 树上的一个错误,Hash是出错的数据在数据库中的key,Path是从root到这个节点的路径
+type TrieError struct {
+	Hash   string `json:"hash"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (err TrieError) Error() string {
+	return fmt.Sprintf("key=%s path=%s: %s", err.Hash, err.Path, err.Reason)
+}
+
+/*
+*检查root对应的树是否完整,返回所有发现的错误
+*
+*检查每个节点和Value的Hash是否和内容一致,子节点是否缺失,Sons是否有序并且第一个字节不重复
+*checked中的节点不会被重复检查,检查多棵树时可以共用checked,跳过相同的子树
+ */
+func CheckTrie(db db.KVStore, root []byte, checked map[string]bool) []TrieError {
+	errs := make([]TrieError, 0)
+	tree := MTP_Tree(db, root)
+	tree.check(root, nil, nil, true, checked, &errs)
+	return errs
+}
+
+func (this *MTP) check(hash, path, pathValue []byte, root bool, checked map[string]bool, errs *[]TrieError) {
+	if checked[string(hash)] {
+		return
+	}
+	checked[string(hash)] = true
+	report := func(reason string, args ...interface{}) {
+		*errs = append(*errs, TrieError{Hash: hex.EncodeToString(hash), Path: hex.EncodeToString(path), Reason: fmt.Sprintf(reason, args...)})
+	}
+	data, err := this.DB.Get(hash)
+	if err != nil || len(data) == 0 {
+		report("missing node")
+		return
+	}
+	if crypto.Validate(data, hash) != nil {
+		report("hash mismatch")
+		return
+	}
+	node, err := DecodeNode(data)
+	if err != nil {
+		report("decode failed, %v", err)
+		return
+	}
+	if node.Root != root {
+		report("unexpected root flag %v", node.Root)
+	}
+	if !root {
+		if len(node.PathValue) == 0 {
+			report("empty path value")
+		} else if !bytes.Equal(node.PathValue, pathValue) {
+			report("path value is different from parent")
+		}
+		path = append(append(make([]byte, 0, len(path)+len(node.PathValue)), path...), node.PathValue...)
+	}
+	if node.Leaf {
+		if len(node.Sons) != 1 || len(node.Sons[0].PathValue) != 0 {
+			report("leaf node must have exactly one son with empty path value")
+			return
+		}
+		valueHash := node.Sons[0].Hash
+		if checked[string(valueHash)] {
+			return
+		}
+		checked[string(valueHash)] = true
+		value, err := this.DB.Get(valueHash)
+		if err != nil || len(value) == 0 {
+			*errs = append(*errs, TrieError{Hash: hex.EncodeToString(valueHash), Path: hex.EncodeToString(path), Reason: "missing value"})
+		} else if crypto.Validate(value, valueHash) != nil {
+			*errs = append(*errs, TrieError{Hash: hex.EncodeToString(valueHash), Path: hex.EncodeToString(path), Reason: "value hash mismatch"})
+		}
+		return
+	}
+	if !root && len(node.Sons) < 2 {
+		report("internal node has %d sons", len(node.Sons))
+	}
+	for i, son := range node.Sons {
+		if len(son.PathValue) == 0 {
+			report("son %d has empty path value", i)
+			continue
+		}
+		if i > 0 && len(node.Sons[i-1].PathValue) > 0 && node.Sons[i-1].PathValue[0] >= son.PathValue[0] {
+			report("sons are not sorted at %d", i)
+		}
+		this.check(son.Hash, path, son.PathValue, false, checked, errs)
+	}
+}
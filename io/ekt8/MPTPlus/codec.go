@@ -0,0 +1,161 @@
+package MPTPlus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/rlp"
+)
+
+// 树节点的编码方式,节点的Hash是编码之后的数据的Hash,所以同一条链上的所有节点必须使用相同的编码
+type Encoding byte
+
+const (
+	JSONEncoding Encoding = iota
+	RLPEncoding
+)
+
+// 二进制编码的第一个字节是版本号,JSON编码的第一个字节一定是'{',读取时根据第一个字节区分两种编码
+const (
+	rlpNodeV1 byte = 0x01
+)
+
+const (
+	leafFlag uint = 1 << iota
+	rootFlag
+)
+
+// 新建的树使用的编码,启动时通过InitEncoding设置
+var DefaultEncoding = JSONEncoding
+
+var InvalidEncoding = errors.New("Invalid encoding")
+
+// 数据库中保存的这条链使用的编码
+var EncodingKey = db.MetaNamespace.Key([]byte("trieEncoding"))
+
+type rlpSon struct {
+	PathValue []byte
+	Hash      []byte
+}
+
+type rlpNode struct {
+	Flags     uint
+	PathValue []byte
+	Sons      []rlpSon
+}
+
+func ParseEncoding(name string) (Encoding, error) {
+	switch name {
+	case "", "json":
+		return JSONEncoding, nil
+	case "rlp":
+		return RLPEncoding, nil
+	}
+	return JSONEncoding, InvalidEncoding
+}
+
+func (encoding Encoding) String() string {
+	switch encoding {
+	case JSONEncoding:
+		return "json"
+	case RLPEncoding:
+		return "rlp"
+	}
+	return "unknown"
+}
+
+/*
+*检查配置的编码和数据库中的链使用的编码是否一致,一致时设置DefaultEncoding
+*
+*编码是链的参数,创世块的Root和编码有关,所以同一条链上的节点必须使用相同的编码
+*第一次启动时把配置的编码写入数据库,之后配置的编码和数据库中的不一致时拒绝打开数据库,已经写入的节点不会被重新编码
+*记录编码之前已经有区块的数据库中都是JSON编码的节点
+ */
+func InitEncoding(store db.KVStore, name string) error {
+	encoding, err := ParseEncoding(name)
+	if err != nil {
+		return err
+	}
+	data, err := store.Get(EncodingKey)
+	if err != nil && err != db.ErrNotFound {
+		return err
+	}
+	stored := string(data)
+	if err == db.ErrNotFound {
+		stored = encoding.String()
+		if hasBlocks(store) {
+			stored = JSONEncoding.String()
+		}
+		if err = store.Set(EncodingKey, []byte(stored)); err != nil {
+			return err
+		}
+	}
+	if stored != encoding.String() {
+		return fmt.Errorf("Trie encoding mismatch, the database uses %s but %s is configured", stored, encoding.String())
+	}
+	DefaultEncoding = encoding
+	return nil
+}
+
+func hasBlocks(store db.KVStore) bool {
+	iter := store.NewIterator(db.HeadNamespace.Prefix())
+	defer iter.Release()
+	return iter.Next()
+}
+
+func EncodeNode(node TrieNode, encoding Encoding) ([]byte, error) {
+	switch encoding {
+	case JSONEncoding:
+		return json.Marshal(node)
+	case RLPEncoding:
+		n := rlpNode{PathValue: node.PathValue, Sons: make([]rlpSon, 0, len(node.Sons))}
+		if node.Leaf {
+			n.Flags |= leafFlag
+		}
+		if node.Root {
+			n.Flags |= rootFlag
+		}
+		for _, son := range node.Sons {
+			n.Sons = append(n.Sons, rlpSon{PathValue: son.PathValue, Hash: son.Hash})
+		}
+		data, err := rlp.Encode(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{rlpNodeV1}, data...), nil
+	}
+	return nil, InvalidEncoding
+}
+
+// 可以解码所有版本的编码,包括旧的JSON编码
+func DecodeNode(data []byte) (*TrieNode, error) {
+	if len(data) == 0 {
+		return nil, InvalidEncoding
+	}
+	switch data[0] {
+	case '{':
+		var node TrieNode
+		err := json.Unmarshal(data, &node)
+		return &node, err
+	case rlpNodeV1:
+		var n rlpNode
+		if err := rlp.Decode(data[1:], &n); err != nil {
+			return nil, err
+		}
+		node := &TrieNode{
+			Leaf:      n.Flags&leafFlag != 0,
+			Root:      n.Flags&rootFlag != 0,
+			PathValue: n.PathValue,
+		}
+		if len(n.Sons) > 0 {
+			node.Sons = make(SortedSon, 0, len(n.Sons))
+			for _, son := range n.Sons {
+				node.Sons = append(node.Sons, TrieSonInfo{Hash: son.Hash, PathValue: son.PathValue})
+			}
+		}
+		return node, nil
+	}
+	return nil, InvalidEncoding
+}
@@ -0,0 +1,176 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+type DiffEntry struct {
+	Key      common.HexBytes `json:"key"`
+	OldValue common.HexBytes `json:"oldValue"`
+	NewValue common.HexBytes `json:"newValue"`
+}
+
+type TrieDiff struct {
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+	Changed []DiffEntry `json:"changed"`
+}
+
+// 比较时使用的儿子节点信息,path是相对于当前比较位置的路径,base是节点的Parent的完整路径
+// 当两棵树在同一个位置的节点的PathValue不一样长时,较长的一方会被截断,截断之后path是PathValue的后缀
+type diffSon struct {
+	path []byte
+	hash []byte
+	base []byte
+}
+
+/*
+*比较rootA和rootB对应的两棵树,返回从A到B新增、删除和修改的key
+*
+*两棵树在同一个位置上Hash相同的子树会被直接跳过,不会读取子树中的节点
+ */
+func Diff(db db.KVStore, rootA, rootB []byte) (*TrieDiff, error) {
+	diff := &TrieDiff{
+		Added:   make([]DiffEntry, 0),
+		Removed: make([]DiffEntry, 0),
+		Changed: make([]DiffEntry, 0),
+	}
+	if bytes.Equal(rootA, rootB) {
+		return diff, nil
+	}
+	treeA, treeB := MTP_Tree(db, rootA), MTP_Tree(db, rootB)
+	nodeA, err := treeA.GetNode(rootA)
+	if err != nil {
+		return nil, err
+	}
+	nodeB, err := treeB.GetNode(rootB)
+	if err != nil {
+		return nil, err
+	}
+	if nodeA == nil || nodeB == nil {
+		return nil, errors.New("Missing node")
+	}
+	err = diff.diffSons(treeA, treeB, nil, toDiffSons(nodeA.Sons, nil), toDiffSons(nodeB.Sons, nil))
+	return diff, err
+}
+
+func toDiffSons(sons SortedSon, base []byte) []diffSon {
+	result := make([]diffSon, 0, len(sons))
+	for _, son := range sons {
+		result = append(result, diffSon{path: son.PathValue, hash: son.Hash, base: base})
+	}
+	return result
+}
+
+// 兄弟节点的PathValue的第一个字节一定不相同,并且是按照字节序排列的
+func (diff *TrieDiff) diffSons(treeA, treeB *MTP, prefix []byte, sonsA, sonsB []diffSon) error {
+	i, j := 0, 0
+	for i < len(sonsA) || j < len(sonsB) {
+		if j == len(sonsB) || (i < len(sonsA) && sonsA[i].path[0] < sonsB[j].path[0]) {
+			if err := diff.removeAll(treeA, sonsA[i]); err != nil {
+				return err
+			}
+			i++
+		} else if i == len(sonsA) || sonsA[i].path[0] > sonsB[j].path[0] {
+			if err := diff.addAll(treeB, sonsB[j]); err != nil {
+				return err
+			}
+			j++
+		} else {
+			if err := diff.diffSon(treeA, treeB, prefix, sonsA[i], sonsB[j]); err != nil {
+				return err
+			}
+			i++
+			j++
+		}
+	}
+	return nil
+}
+
+func (diff *TrieDiff) diffSon(treeA, treeB *MTP, prefix []byte, sonA, sonB diffSon) error {
+	length := PrefixLength(sonA.path, sonB.path)
+	if length < len(sonA.path) && length < len(sonB.path) {
+		// 两个子树在这里分叉,没有相同的key
+		if err := diff.removeAll(treeA, sonA); err != nil {
+			return err
+		}
+		return diff.addAll(treeB, sonB)
+	}
+	if len(sonA.path) == len(sonB.path) && bytes.Equal(sonA.hash, sonB.hash) {
+		return nil
+	}
+	nodeA, err := treeA.GetNode(sonA.hash)
+	if err != nil {
+		return err
+	}
+	nodeB, err := treeB.GetNode(sonB.hash)
+	if err != nil {
+		return err
+	}
+	if nodeA == nil || nodeB == nil {
+		return errors.New("Missing node")
+	}
+	path := append(append(make([]byte, 0, len(prefix)+length), prefix...), sonA.path[:length]...)
+	switch {
+	case len(sonA.path) == len(sonB.path):
+		if nodeA.Leaf && nodeB.Leaf {
+			return diff.change(treeA, treeB, path, nodeA, nodeB)
+		}
+		if nodeA.Leaf || nodeB.Leaf {
+			break
+		}
+		return diff.diffSons(treeA, treeB, path, toDiffSons(nodeA.Sons, path), toDiffSons(nodeB.Sons, path))
+	case len(sonA.path) < len(sonB.path):
+		if nodeA.Leaf {
+			break
+		}
+		sonB.path = sonB.path[length:]
+		return diff.diffSons(treeA, treeB, path, toDiffSons(nodeA.Sons, path), []diffSon{sonB})
+	default:
+		if nodeB.Leaf {
+			break
+		}
+		sonA.path = sonA.path[length:]
+		return diff.diffSons(treeA, treeB, path, []diffSon{sonA}, toDiffSons(nodeB.Sons, path))
+	}
+	if err := diff.removeAll(treeA, sonA); err != nil {
+		return err
+	}
+	return diff.addAll(treeB, sonB)
+}
+
+func (diff *TrieDiff) change(treeA, treeB *MTP, key []byte, leafA, leafB *TrieNode) error {
+	if bytes.Equal(leafA.Sons[0].Hash, leafB.Sons[0].Hash) {
+		return nil
+	}
+	oldValue, err := treeA.getData(leafA.Sons[0].Hash)
+	if err != nil {
+		return err
+	}
+	newValue, err := treeB.getData(leafB.Sons[0].Hash)
+	if err != nil {
+		return err
+	}
+	diff.Changed = append(diff.Changed, DiffEntry{Key: key, OldValue: oldValue, NewValue: newValue})
+	return nil
+}
+
+func (diff *TrieDiff) removeAll(tree *MTP, son diffSon) error {
+	_, err := tree.iterate(son.hash, son.base, nil, nil, func(key, value []byte) bool {
+		diff.Removed = append(diff.Removed, DiffEntry{Key: key, OldValue: value})
+		return true
+	})
+	return err
+}
+
+func (diff *TrieDiff) addAll(tree *MTP, son diffSon) error {
+	_, err := tree.iterate(son.hash, son.base, nil, nil, func(key, value []byte) bool {
+		diff.Added = append(diff.Added, DiffEntry{Key: key, NewValue: value})
+		return true
+	})
+	return err
+}
@@ -0,0 +1,103 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+)
+
+// 遍历时对每个key/value调用的函数,返回false时停止遍历
+// 遍历过程中持有树的读锁,不能在回调函数中修改当前树
+type IterateFunc func(key, value []byte) bool
+
+type TrieEntry struct {
+	Key   common.HexBytes `json:"key"`
+	Value common.HexBytes `json:"value"`
+}
+
+/*
+*按照key的字节序遍历[start, end)区间内的所有key
+*
+*start为nil表示从第一个key开始,end为nil表示遍历到最后一个key
+*TrieNode的Sons是按照PathValue排序的,所以深度优先遍历得到的key是有序的,不在区间内的子树会被直接跳过
+ */
+func (this *MTP) Iterate(start, end []byte, fn IterateFunc) error {
+	this.Lock.RLock()
+	defer this.Lock.RUnlock()
+	_, err := this.iterate(this.Root, nil, start, end, fn)
+	return err
+}
+
+// 遍历所有以prefix开头的key
+func (this *MTP) IteratePrefix(prefix []byte, fn IterateFunc) error {
+	return this.Iterate(prefix, PrefixEnd(prefix), fn)
+}
+
+/*
+*分页获取从start开始的limit个key/value
+*
+*next是下一页的start,没有下一页时next为nil
+ */
+func (this *MTP) Scan(start []byte, limit int) (entries []TrieEntry, next []byte, err error) {
+	entries = make([]TrieEntry, 0)
+	err = this.Iterate(start, nil, func(key, value []byte) bool {
+		if len(entries) == limit {
+			next = key
+			return false
+		}
+		entries = append(entries, TrieEntry{Key: key, Value: value})
+		return true
+	})
+	return
+}
+
+func (this *MTP) iterate(hash, prefix, start, end []byte, fn IterateFunc) (bool, error) {
+	node, err := this.GetNode(hash)
+	if err != nil {
+		return false, err
+	}
+	if node == nil {
+		return false, errors.New("Missing node")
+	}
+	if !node.Root {
+		prefix = append(append(make([]byte, 0, len(prefix)+len(node.PathValue)), prefix...), node.PathValue...)
+	}
+	// 子树中所有的key都以prefix开头
+	if len(end) > 0 && bytes.Compare(prefix, end) >= 0 {
+		return false, nil
+	}
+	if len(start) > 0 && bytes.Compare(prefix, start) < 0 && !bytes.HasPrefix(start, prefix) {
+		return true, nil
+	}
+	if node.Leaf {
+		if len(start) > 0 && bytes.Compare(prefix, start) < 0 {
+			return true, nil
+		}
+		value, err := this.getData(node.Sons[0].Hash)
+		if err != nil {
+			return false, err
+		}
+		return fn(prefix, value), nil
+	}
+	for _, son := range node.Sons {
+		goon, err := this.iterate(son.Hash, prefix, start, end, fn)
+		if err != nil || !goon {
+			return goon, err
+		}
+	}
+	return true, nil
+}
+
+// 返回比所有以prefix开头的key都大的最小的key,prefix全部是0xff时返回nil
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
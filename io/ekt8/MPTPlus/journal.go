@@ -0,0 +1,33 @@
+package MPTPlus
+
+import (
+	"errors"
+)
+
+var InvalidSnapshotId = errors.New("Invalid snapshot id")
+
+/*
+*保存当前的状态,返回快照的id,之后可以通过RevertToSnapshot回滚到这个状态,Commit之后所有的快照都会失效
+*
+*回滚只需要恢复Root,不需要从数据库中删除数据。需要先调用StartBatch开启批量写入,
+*这样回滚之前产生的节点只保存在内存中,Commit时只有最终Root能访问到的节点会写入数据库
+*没有开启批量写入时被回滚的节点已经写入了数据库,只能等删除历史状态时删除
+ */
+func (this *MTP) Snapshot() int {
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+	this.journal = append(this.journal, this.Root)
+	return len(this.journal) - 1
+}
+
+// 回滚到id对应的快照,id之后的快照都会失效
+func (this *MTP) RevertToSnapshot(id int) error {
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+	if id < 0 || id >= len(this.journal) {
+		return InvalidSnapshotId
+	}
+	this.Root = this.journal[id]
+	this.journal = this.journal[:id]
+	return nil
+}
@@ -0,0 +1,126 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+)
+
+// Proof是从root节点到key对应的叶子节点路径上的所有节点,第一个节点是root节点
+// 如果key不存在,Proof在key和树分叉的节点处结束,可以用来证明key不在树上
+type Proof []TrieNode
+
+var (
+	InvalidProof    = errors.New("Invalid Proof")
+	IncompleteProof = errors.New("Incomplete Proof")
+)
+
+func (this *MTP) GetProof(key []byte) (Proof, error) {
+	this.Lock.RLock()
+	defer this.Lock.RUnlock()
+	node, err := this.GetNode(this.Root)
+	if err != nil || node == nil {
+		return nil, errors.New("Not Exist")
+	}
+	proof := Proof{*node}
+	left := key
+	for {
+		var next *TrieSonInfo
+		for i, son := range node.Sons {
+			if PrefixLength(son.PathValue, left) > 0 {
+				next = &node.Sons[i]
+				break
+			}
+		}
+		if next == nil {
+			return proof, nil
+		}
+		node, err = this.GetNode(next.Hash)
+		if err != nil || node == nil {
+			return nil, errors.New("Missing node")
+		}
+		proof = append(proof, *node)
+		if PrefixLength(node.PathValue, left) < len(node.PathValue) {
+			return proof, nil
+		}
+		left = left[len(node.PathValue):]
+		if node.Leaf {
+			return proof, nil
+		}
+	}
+}
+
+/*
+*校验proof,不需要访问数据库
+*
+*value不为nil时,校验key在root对应的树上的值是value
+*value为nil时,校验key不在root对应的树上
+ */
+func VerifyProof(root, key, value []byte, proof Proof) error {
+	if len(proof) == 0 || !proof[0].Root {
+		return InvalidProof
+	}
+	hash, left := root, key
+	for i, node := range proof {
+		if !matchHash(node, hash) {
+			return InvalidProof
+		}
+		last := i == len(proof)-1
+		if !node.Root {
+			if PrefixLength(node.PathValue, left) < len(node.PathValue) {
+				// key在当前节点分叉,key不存在
+				return verifyAbsent(last, value)
+			}
+			left = left[len(node.PathValue):]
+		}
+		if node.Leaf {
+			if len(left) != 0 || len(node.Sons) != 1 {
+				return verifyAbsent(last, value)
+			}
+			if !last {
+				return InvalidProof
+			}
+			if value == nil || !bytes.Equal(crypto.Sha3_256(value), node.Sons[0].Hash) {
+				return InvalidProof
+			}
+			return nil
+		}
+		var next *TrieSonInfo
+		for j, son := range node.Sons {
+			if PrefixLength(son.PathValue, left) > 0 {
+				next = &node.Sons[j]
+				break
+			}
+		}
+		if next == nil {
+			return verifyAbsent(last, value)
+		}
+		if last {
+			return IncompleteProof
+		}
+		if !bytes.Equal(next.PathValue, proof[i+1].PathValue) {
+			return InvalidProof
+		}
+		hash = next.Hash
+	}
+	return IncompleteProof
+}
+
+// proof中不包含节点的编码方式,任意一种编码的Hash一致即可
+func matchHash(node TrieNode, hash []byte) bool {
+	for _, encoding := range []Encoding{JSONEncoding, RLPEncoding} {
+		data, err := EncodeNode(node, encoding)
+		if err == nil && bytes.Equal(crypto.Sha3_256(data), hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyAbsent(last bool, value []byte) error {
+	if !last || value != nil {
+		return InvalidProof
+	}
+	return nil
+}
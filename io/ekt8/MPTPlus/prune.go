@@ -0,0 +1,162 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+const pruneBatchSize = 1000
+
+// TrieNode序列化之后的前缀,用来从数据库中区分树的节点和其他数据
+var nodePrefixes = [][]byte{[]byte(`{"Sons":`), []byte{rlpNodeV1}}
+
+/*
+*在线删除时记录删除开始之后写入数据库的节点和Value
+*
+*数据库是按内容寻址的,删除开始时已经不能访问到的节点可能在删除过程中被重新提交,这时数据库中的数据没有变化,
+*只能通过写入的Hash知道这个节点重新被使用了。所有树的写入和每一批删除都持有locker,
+*删除之前在locker中检查written,保证检查和删除之间不会有新的写入
+ */
+var writes = struct {
+	locker  sync.Mutex
+	pruning int
+	written map[string]bool
+}{}
+
+// 把树的节点或Value写入数据库,正在删除时记录写入的Hash
+func writeNodes(hashes [][]byte, write func() error) error {
+	writes.locker.Lock()
+	defer writes.locker.Unlock()
+	if writes.pruning > 0 {
+		for _, hash := range hashes {
+			writes.written[string(hash)] = true
+		}
+	}
+	return write()
+}
+
+func startRecordWrites() {
+	writes.locker.Lock()
+	defer writes.locker.Unlock()
+	if writes.pruning == 0 {
+		writes.written = make(map[string]bool)
+	}
+	writes.pruning++
+}
+
+func stopRecordWrites() {
+	writes.locker.Lock()
+	defer writes.locker.Unlock()
+	if writes.pruning--; writes.pruning == 0 {
+		writes.written = nil
+	}
+}
+
+/*
+*删除数据库中所有不能从roots访问到的树节点,以及这些节点引用的Value,返回删除的节点数量
+*
+*先从roots开始标记所有可以访问到的节点和Value,然后遍历数据库删除没有被标记的节点
+*如果roots对应的树上缺少节点则放弃删除,防止误删缺失节点的子节点
+*遍历的是开始标记之前的数据库快照,删除过程中新写入的节点不在快照中,
+*快照中已经存在但是删除开始之后又被重新写入的节点和Value也不会被删除,所以删除时不需要停止提交
+ */
+func Prune(db db.KVStore, roots [][]byte) (int, error) {
+	return prune(db, roots, nil)
+}
+
+// afterMark在标记完成之后、删除之前调用,只在测试中使用
+func prune(db db.KVStore, roots [][]byte, afterMark func()) (int, error) {
+	startRecordWrites()
+	defer stopRecordWrites()
+	iter := db.NewIterator(nil)
+	defer iter.Release()
+
+	marked := make(map[string]bool)
+	tree := MTP_Tree(db, nil)
+	for _, root := range roots {
+		if err := tree.mark(root, marked); err != nil {
+			return 0, err
+		}
+	}
+	if afterMark != nil {
+		afterMark()
+	}
+
+	keys, count := make([][]byte, 0, pruneBatchSize), 0
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(key) != 32 || marked[string(key)] || !hasNodePrefix(value) {
+			continue
+		}
+		if crypto.Validate(value, key) != nil {
+			continue
+		}
+		node, err := DecodeNode(value)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, append([]byte{}, key...))
+		if node.Leaf && len(node.Sons) == 1 && !marked[string(node.Sons[0].Hash)] {
+			keys = append(keys, node.Sons[0].Hash)
+		}
+		count++
+		if len(keys) >= pruneBatchSize {
+			if err := deleteUnwritten(db, keys); err != nil {
+				return count, err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return count, err
+	}
+	return count, deleteUnwritten(db, keys)
+}
+
+// 删除keys中删除开始之后没有被重新写入的数据
+func deleteUnwritten(db db.KVStore, keys [][]byte) error {
+	writes.locker.Lock()
+	defer writes.locker.Unlock()
+	batch := db.NewBatch()
+	for _, key := range keys {
+		if !writes.written[string(key)] {
+			batch.Delete(key)
+		}
+	}
+	return db.WriteBatch(batch)
+}
+
+func hasNodePrefix(value []byte) bool {
+	for _, prefix := range nodePrefixes {
+		if bytes.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *MTP) mark(hash []byte, marked map[string]bool) error {
+	if marked[string(hash)] {
+		return nil
+	}
+	node, err := this.GetNode(hash)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return errors.New("Missing node")
+	}
+	marked[string(hash)] = true
+	for _, son := range node.Sons {
+		if node.Leaf {
+			marked[string(son.Hash)] = true
+		} else if err := this.mark(son.Hash, marked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package MPTPlus
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+const (
+	DefaultSyncWorkers = 16
+	// 每个hash最多在每个peer上重试的次数
+	syncRetryPerPeer = 3
+)
+
+const (
+	syncNode byte = iota + 1
+	syncValue
+)
+
+var NoSyncPeer = errors.New("No peer to sync")
+
+// 可以根据hash获取数据的节点,p2p.Peer实现了这个接口
+type SyncPeer interface {
+	GetDBValue(key []byte) ([]byte, error)
+}
+
+type SyncProgress struct {
+	Synced  int   `json:"synced"`  // 已经同步的节点和Value的数量
+	Pending int   `json:"pending"` // 还没有同步的数量
+	Bytes   int64 `json:"bytes"`   // 已经同步的数据大小
+	Retries int   `json:"retries"` // 失败重试的次数
+}
+
+/*
+*从其他节点同步root对应的树上本地缺少的节点和Value
+*
+*缺少的hash会被保存在数据库中,同一个root的同步中断之后再次运行会从上次的位置继续
+*每个节点写入数据库的同时会把它的子节点加入队列,如果子节点在本地已经存在,认为子节点对应的子树是完整的
+ */
+type StateSync struct {
+	DB       db.KVStore
+	Root     []byte
+	Peers    []SyncPeer
+	Workers  int
+	locker   sync.RWMutex
+	progress SyncProgress
+}
+
+type syncTask struct {
+	hash    []byte
+	kind    byte
+	attempt int
+}
+
+type syncResult struct {
+	task syncTask
+	data []byte
+	err  error
+}
+
+func NewStateSync(db db.KVStore, root []byte, peers []SyncPeer) *StateSync {
+	return &StateSync{DB: db, Root: root, Peers: peers, Workers: DefaultSyncWorkers}
+}
+
+// 同步root对应的树,树完整之后返回
+func SyncRoot(db db.KVStore, root []byte, peers p2p.Peers) error {
+	syncPeers := make([]SyncPeer, 0, len(peers))
+	for _, peer := range peers {
+		syncPeers = append(syncPeers, peer)
+	}
+	return NewStateSync(db, root, syncPeers).Run()
+}
+
+func (s *StateSync) Progress() SyncProgress {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	return s.progress
+}
+
+func (s *StateSync) Run() error {
+	if len(s.Peers) == 0 {
+		return NoSyncPeer
+	}
+	pending, err := s.loadQueue()
+	if err != nil {
+		return err
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultSyncWorkers
+	}
+	tasks, results := make(chan syncTask), make(chan syncResult)
+	defer close(tasks)
+	for i := 0; i < workers; i++ {
+		go s.worker(tasks, results)
+	}
+
+	inflight := 0
+	s.setPending(len(pending))
+	for len(pending) > 0 || inflight > 0 {
+		var next chan syncTask
+		var task syncTask
+		if len(pending) > 0 {
+			next, task = tasks, pending[len(pending)-1]
+		}
+		select {
+		case next <- task:
+			pending = pending[:len(pending)-1]
+			inflight++
+		case result := <-results:
+			inflight--
+			if result.err != nil {
+				result.task.attempt++
+				if result.task.attempt >= len(s.Peers)*syncRetryPerPeer {
+					// 等待正在进行的任务结束,已经同步的数据和队列都已经写入数据库,下次可以继续同步
+					for ; inflight > 0; inflight-- {
+						s.save(<-results)
+					}
+					return result.err
+				}
+				s.locker.Lock()
+				s.progress.Retries++
+				s.locker.Unlock()
+				pending = append(pending, result.task)
+				continue
+			}
+			children, err := s.save(result)
+			if err != nil {
+				for ; inflight > 0; inflight-- {
+					s.save(<-results)
+				}
+				return err
+			}
+			pending = append(pending, children...)
+		}
+		s.setPending(len(pending) + inflight)
+	}
+	return nil
+}
+
+func (s *StateSync) worker(tasks <-chan syncTask, results chan<- syncResult) {
+	for task := range tasks {
+		peer := s.Peers[task.attempt%len(s.Peers)]
+		data, err := peer.GetDBValue(task.hash)
+		if err == nil {
+			err = crypto.Validate(data, task.hash)
+		}
+		if err == nil && task.kind == syncNode {
+			_, err = DecodeNode(data)
+		}
+		results <- syncResult{task: task, data: data, err: err}
+	}
+}
+
+// 把同步到的数据写入数据库,同时把本地缺少的子节点加入队列
+func (s *StateSync) save(result syncResult) ([]syncTask, error) {
+	if result.err != nil {
+		return nil, nil
+	}
+	children := make([]syncTask, 0)
+	if result.task.kind == syncNode {
+		node, _ := DecodeNode(result.data)
+		for _, son := range node.Sons {
+			kind := syncNode
+			if node.Leaf {
+				kind = syncValue
+			}
+			if exist, _ := s.DB.Has(son.Hash); exist {
+				continue
+			}
+			children = append(children, syncTask{hash: son.Hash, kind: kind})
+		}
+	}
+	batch := s.DB.NewBatch()
+	batch.Put(result.task.hash, result.data)
+	batch.Delete(s.queueKey(result.task.hash))
+	for _, child := range children {
+		batch.Put(s.queueKey(child.hash), []byte{child.kind})
+	}
+	if err := writeNodes([][]byte{result.task.hash}, func() error { return s.DB.WriteBatch(batch) }); err != nil {
+		return nil, err
+	}
+	s.locker.Lock()
+	s.progress.Synced++
+	s.progress.Bytes += int64(len(result.data))
+	s.locker.Unlock()
+	return children, nil
+}
+
+// 读取上次没有完成的队列,没有队列时从root开始同步
+func (s *StateSync) loadQueue() ([]syncTask, error) {
+	prefix := s.queueKey(nil)
+	pending := make([]syncTask, 0)
+	iter := s.DB.NewIterator(prefix)
+	for iter.Next() {
+		hash := append([]byte{}, iter.Key()[len(prefix):]...)
+		pending = append(pending, syncTask{hash: hash, kind: iter.Value()[0]})
+	}
+	err := iter.Error()
+	iter.Release()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return pending, nil
+	}
+	if exist, _ := s.DB.Has(s.Root); exist {
+		return pending, nil
+	}
+	return append(pending, syncTask{hash: s.Root, kind: syncNode}), nil
+}
+
+func (s *StateSync) queueKey(hash []byte) []byte {
+	return append(db.StateSyncNamespace.Prefix(s.Root), hash...)
+}
+
+func (s *StateSync) setPending(pending int) {
+	s.locker.Lock()
+	s.progress.Pending = pending
+	s.locker.Unlock()
+}
@@ -6,12 +6,8 @@ import (
 	"errors"
 
 	"github.com/EducationEKT/EKT/io/ekt8/crypto"
-	"github.com/EducationEKT/EKT/io/ekt8/db"
-	"github.com/EducationEKT/EKT/io/ekt8/p2p"
 )
 
-var DB db.LevelDB
-
 // Merkle Trie Plus树是一个安全的自校验的字典树的升级,每个节点都带有自己的路径值,叶子节点的
 // 儿子节点存储的是Value的Hash值,根据Hash可以在levelDB上获取自己的Value
 // key=strings.Join(pathValues, "") value=db.Get(leafNode.Sons[0].Hash)
@@ -62,7 +58,7 @@ func (this *MTP) GetValue(key []byte) (value []byte, err error) {
 		}
 	}
 	if find {
-		return this.DB.Get(vHash)
+		return this.getData(vHash)
 	}
 	return nil, nil
 }
@@ -110,32 +106,78 @@ func (this *MTP) Update(key, value []byte) error {
 	return err
 }
 
-func SyncDB(key []byte, peers p2p.Peers, leaf bool) {
-	if _, err := db.GetDBInst().Get(key); err != nil {
-		for _, peer := range peers {
-			value, err := peer.GetDBValue(key)
+/**
+*把Key从树上删除
+*
+*删除叶子节点之后向上回溯更新Parent节点,如果某个非root节点只剩下一个儿子节点,则把它和儿子节点合并,
+*保证删除之后的root和从未插入过这个key的树的root一致
+ */
+func (this *MTP) Delete(key []byte) error {
+	this.Lock.Lock()
+	defer this.Lock.Unlock()
+	if !this.ContainsKey(key) {
+		return errors.New("Not Exist")
+	}
+	parentHashes, _, err := this.FindParents(key)
+	if err != nil {
+		return err
+	}
+	leafNode, err := this.GetNode(parentHashes[len(parentHashes)-1])
+	if err != nil {
+		return err
+	}
+
+	// newHash_为nil表示oldPrefix_对应的儿子节点已经被删除
+	oldPrefix_, newPrefix_ := leafNode.PathValue, leafNode.PathValue
+	var newHash_ []byte
+	for i := len(parentHashes) - 2; i >= 0; i-- {
+		currentNode, err := this.GetNode(parentHashes[i])
+		if err != nil {
+			return err
+		}
+		currentNode.DeleteSon(oldPrefix_)
+		if newHash_ != nil {
+			currentNode.AddSon(newHash_, newPrefix_)
+		}
+		switch len(currentNode.Sons) {
+		case 0:
+			oldPrefix_, newHash_ = currentNode.PathValue, nil
+		case 1:
+			sonNode, err := this.GetNode(currentNode.Sons[0].Hash)
 			if err != nil {
-				continue
+				return err
 			}
-			if crypto.Validate(value, key) != nil {
-				continue
+			pathValue := make([]byte, 0, len(currentNode.PathValue)+len(sonNode.PathValue))
+			pathValue = append(pathValue, currentNode.PathValue...)
+			sonNode.PathValue = append(pathValue, sonNode.PathValue...)
+			newHash_, err = this.SaveNode(*sonNode)
+			if err != nil {
+				return err
 			}
-			db.GetDBInst().Set(key, value)
-			if !leaf {
-				var node TrieNode
-				err = json.Unmarshal(value, &node)
-				if err != nil {
-					continue
-				}
-				if len(node.Sons) > 0 {
-					for _, son := range node.Sons {
-						go SyncDB(son.Hash, peers, node.Leaf)
-					}
-				}
+			oldPrefix_, newPrefix_ = currentNode.PathValue, sonNode.PathValue
+		default:
+			newHash_, err = this.SaveNode(*currentNode)
+			if err != nil {
+				return err
 			}
-			break
+			oldPrefix_, newPrefix_ = currentNode.PathValue, currentNode.PathValue
 		}
 	}
+
+	rootNode, err := this.GetNode(this.Root)
+	if err != nil {
+		return err
+	}
+	rootNode.DeleteSon(oldPrefix_)
+	if newHash_ != nil {
+		rootNode.AddSon(newHash_, newPrefix_)
+	}
+	if len(rootNode.Sons) == 0 {
+		// 和NewMTP生成的空树保持一致
+		rootNode.Sons = nil
+	}
+	this.Root, err = this.SaveNode(*rootNode)
+	return err
 }
 
 /**
@@ -233,17 +275,22 @@ func (this *MTP) FindParents(key []byte) (parentHashes [][]byte, prefixs [][]byt
 }
 
 func (this *MTP) GetNode(hash []byte) (*TrieNode, error) {
+	if node := this.getBatch().getNode(hash); node != nil {
+		return node, nil
+	}
 	data, err := this.DB.Get(hash)
 	if err != nil || len(data) == 0 {
 		return nil, err
 	}
-	var node TrieNode
-	err = json.Unmarshal(data, &node)
-	return &node, err
+	return DecodeNode(data)
 }
 
+// 批量写入时节点只保存在内存中,返回的是临时key,Hash或Commit时才计算节点的Hash
 func (this *MTP) SaveNode(node TrieNode) (nodeHash []byte, err error) {
-	data, err := json.Marshal(node)
+	if batch := this.getBatch(); batch != nil {
+		return batch.setDirty(&node), nil
+	}
+	data, err := EncodeNode(node, this.Encoding)
 	if err != nil {
 		return nil, err
 	}
@@ -252,7 +299,18 @@ func (this *MTP) SaveNode(node TrieNode) (nodeHash []byte, err error) {
 
 func (this *MTP) SaveValue(value []byte) ([]byte, error) {
 	hash := crypto.Sha3_256(value)
-	return hash, this.DB.Set(hash, value)
+	if batch := this.getBatch(); batch != nil {
+		batch.set(hash, value)
+		return hash, nil
+	}
+	return hash, writeNodes([][]byte{hash}, func() error { return this.DB.Set(hash, value) })
+}
+
+func (this *MTP) getData(hash []byte) ([]byte, error) {
+	if data, exist := this.getBatch().get(hash); exist {
+		return data, nil
+	}
+	return this.DB.Get(hash)
 }
 
 //返回公共前缀的长度
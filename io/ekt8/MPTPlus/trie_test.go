@@ -3,14 +3,17 @@ package MPTPlus
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"xserver/x_utils/x_random"
 
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
 	"github.com/EducationEKT/EKT/io/ekt8/db"
 )
 
@@ -36,11 +39,8 @@ func (this RandomKeyValues) Less(i, j int) bool {
 }
 
 func TestMTPInsertAndGet(t *testing.T) {
-	db, err := db.NewLevelDB("testTrie11")
-	if err != nil {
-		t.Fail()
-	}
-	defer db.DB.Close()
+	db := db.NewMemoryDB()
+	var err error
 	var randomKeyValues RandomKeyValues = []KeyValue{
 		KeyValue{[]byte("x"), []byte("this is value9")},
 		KeyValue{[]byte("HZhouWorld"), []byte("this is value4")},
@@ -74,11 +74,8 @@ func TestMTPInsertAndGet(t *testing.T) {
 }
 
 func TestMTPRandomInsert(t *testing.T) {
-	db, err := db.NewLevelDB("testTrie11")
-	if err != nil {
-		t.Fail()
-	}
-	defer db.DB.Close()
+	db := db.NewMemoryDB()
+	var err error
 	var randomKeyValues RandomKeyValues = []KeyValue{
 		KeyValue{[]byte("HZhouWorld1"), []byte("this is value4")},
 		KeyValue{[]byte("HZhouxun"), []byte("this is value3")},
@@ -119,3 +116,606 @@ func TestMTPRandomInsert(t *testing.T) {
 	finishTime := time.Now()
 	fmt.Printf("finishTime=%d\n", finishTime.Nanosecond()-start.Nanosecond())
 }
+
+func TestMTPDelete(t *testing.T) {
+	db := db.NewMemoryDB()
+	var err error
+	var keyValues RandomKeyValues = []KeyValue{
+		KeyValue{[]byte("HZhouWorld1"), []byte("this is value4")},
+		KeyValue{[]byte("HZhouxun"), []byte("this is value3")},
+		KeyValue{[]byte("x"), []byte("this is value9")},
+		KeyValue{[]byte("helloworld"), []byte("this is value7")},
+		KeyValue{[]byte("HelloWorld2"), []byte("this is value1")},
+		KeyValue{[]byte("HZhouWorld2"), []byte("this is value6")},
+		KeyValue{[]byte("HelloX"), []byte("this is value2")},
+		KeyValue{[]byte("HelloWorld1"), []byte("this is value5")},
+		KeyValue{[]byte("zhouxun"), []byte("this is value8")},
+	}
+
+	trie1 := NewMTP(db)
+	for _, kv := range keyValues {
+		if err = trie1.MustInsert(kv.Key, kv.Value); err != nil {
+			t.Fail()
+		}
+	}
+	for i, kv := range keyValues {
+		if err = trie1.Delete(kv.Key); err != nil {
+			fmt.Printf("delete %s failed, err=%v\n", string(kv.Key), err)
+			t.Fail()
+		}
+		if trie1.ContainsKey(kv.Key) {
+			fmt.Printf("key %s still exist after delete\n", string(kv.Key))
+			t.Fail()
+		}
+		trie2 := NewMTP(db)
+		for _, _kv := range keyValues[i+1:] {
+			if err = trie2.MustInsert(_kv.Key, _kv.Value); err != nil {
+				t.Fail()
+			}
+		}
+		if !bytes.Equal(trie1.Root, trie2.Root) {
+			fmt.Printf("after delete %s, trie1.Root=%s, trie2.Root=%s\n", string(kv.Key), hex.EncodeToString(trie1.Root), hex.EncodeToString(trie2.Root))
+			t.Fail()
+		}
+		for _, _kv := range keyValues[i+1:] {
+			v, err := trie1.GetValue(_kv.Key)
+			if err != nil || !bytes.Equal(v, _kv.Value) {
+				fmt.Printf("err=%v, key=%s, value=%s, dbValue=%s\n", err, string(_kv.Key), string(_kv.Value), string(v))
+				t.Fail()
+			}
+		}
+	}
+	if err = trie1.Delete([]byte("HelloX")); err == nil {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestMTPProof(t *testing.T) {
+	db := db.NewMemoryDB()
+	var err error
+	var keyValues RandomKeyValues = []KeyValue{
+		KeyValue{[]byte("HZhouWorld1"), []byte("this is value4")},
+		KeyValue{[]byte("HZhouxun"), []byte("this is value3")},
+		KeyValue{[]byte("x"), []byte("this is value9")},
+		KeyValue{[]byte("helloworld"), []byte("this is value7")},
+		KeyValue{[]byte("HelloWorld2"), []byte("this is value1")},
+		KeyValue{[]byte("HelloX"), []byte("this is value2")},
+	}
+
+	trie := NewMTP(db)
+	for _, kv := range keyValues {
+		if err = trie.MustInsert(kv.Key, kv.Value); err != nil {
+			t.Fail()
+		}
+	}
+	for _, kv := range keyValues {
+		proof, err := trie.GetProof(kv.Key)
+		if err != nil {
+			t.Fail()
+		}
+		if err = VerifyProof(trie.Root, kv.Key, kv.Value, proof); err != nil {
+			fmt.Printf("verify %s failed, err=%v\n", string(kv.Key), err)
+			t.Fail()
+		}
+		if VerifyProof(trie.Root, kv.Key, []byte("error value"), proof) == nil {
+			t.Fail()
+		}
+		if VerifyProof(trie.Root, kv.Key, nil, proof) == nil {
+			t.Fail()
+		}
+	}
+	for _, key := range [][]byte{[]byte("HZhouWorld3"), []byte("HelloY"), []byte("abc"), []byte("HZ")} {
+		proof, err := trie.GetProof(key)
+		if err != nil {
+			t.Fail()
+		}
+		if err = VerifyProof(trie.Root, key, nil, proof); err != nil {
+			fmt.Printf("verify absent key %s failed, err=%v\n", string(key), err)
+			t.Fail()
+		}
+		if VerifyProof(trie.Root, key, []byte("this is value4"), proof) == nil {
+			t.Fail()
+		}
+	}
+	proof, _ := trie.GetProof([]byte("HelloX"))
+	if VerifyProof(trie.Root, []byte("HelloX"), []byte("this is value2"), proof[:len(proof)-1]) == nil {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestMTPIterate(t *testing.T) {
+	db := db.NewMemoryDB()
+	var err error
+	keys := []string{"HZhouWorld1", "HZhouxun", "x", "helloworld", "HelloWorld2", "HZhouWorld2", "HelloX", "HelloWorld1", "zhouxun"}
+	trie := NewMTP(db)
+	for _, key := range keys {
+		if err = trie.MustInsert([]byte(key), []byte("value of "+key)); err != nil {
+			t.Fail()
+		}
+	}
+	sort.Strings(keys)
+
+	collect := func(start, end []byte) []string {
+		result := make([]string, 0)
+		err := trie.Iterate(start, end, func(key, value []byte) bool {
+			if string(value) != "value of "+string(key) {
+				t.Fail()
+			}
+			result = append(result, string(key))
+			return true
+		})
+		if err != nil {
+			t.Fail()
+		}
+		return result
+	}
+	if fmt.Sprint(collect(nil, nil)) != fmt.Sprint(keys) {
+		fmt.Println(collect(nil, nil))
+		t.Fail()
+	}
+	if fmt.Sprint(collect([]byte("HZhouxun"), []byte("helloworld"))) != fmt.Sprint(keys[2:6]) {
+		fmt.Println(collect([]byte("HZhouxun"), []byte("helloworld")))
+		t.Fail()
+	}
+	if fmt.Sprint(collect([]byte("HZhouz"), []byte("i"))) != fmt.Sprint(keys[3:7]) {
+		fmt.Println(collect([]byte("HZhouz"), []byte("i")))
+		t.Fail()
+	}
+
+	prefixKeys := make([]string, 0)
+	trie.IteratePrefix([]byte("HelloWorld"), func(key, value []byte) bool {
+		prefixKeys = append(prefixKeys, string(key))
+		return true
+	})
+	if fmt.Sprint(prefixKeys) != "[HelloWorld1 HelloWorld2]" {
+		fmt.Println(prefixKeys)
+		t.Fail()
+	}
+
+	pages := make([]string, 0)
+	var start []byte
+	for {
+		entries, next, err := trie.Scan(start, 4)
+		if err != nil || len(entries) > 4 {
+			t.Fail()
+			break
+		}
+		for _, entry := range entries {
+			pages = append(pages, string(entry.Key))
+		}
+		if next == nil {
+			break
+		}
+		start = next
+	}
+	if fmt.Sprint(pages) != fmt.Sprint(keys) {
+		fmt.Println(pages)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestMTPBatch(t *testing.T) {
+	// 需要校验哪些节点被写入了数据库,所以使用新的数据库
+	db1 := db.NewMemoryDB()
+	var err error
+	var keyValues RandomKeyValues = []KeyValue{
+		KeyValue{[]byte("HZhouWorld1"), []byte("batch value4")},
+		KeyValue{[]byte("HZhouxun"), []byte("batch value3")},
+		KeyValue{[]byte("x"), []byte("batch value9")},
+		KeyValue{[]byte("helloworld"), []byte("batch value7")},
+		KeyValue{[]byte("HelloWorld2"), []byte("batch value1")},
+		KeyValue{[]byte("HZhouxun"), []byte("batch value6")},
+	}
+
+	trie2 := NewMTP(db1)
+	trie2.StartBatch()
+	var staleRoot []byte
+	for i, kv := range keyValues {
+		if err = trie2.MustInsert(kv.Key, kv.Value); err != nil {
+			t.Fail()
+		}
+		if i == 0 {
+			// 中间状态计算过Hash,但是不能被写入数据库
+			staleRoot, _ = trie2.Hash()
+		}
+	}
+	root, err := trie2.Hash()
+	if err != nil {
+		t.Fail()
+	}
+	if _, err = db1.Get(root); err == nil {
+		fmt.Println("root is written before commit")
+		t.Fail()
+	}
+	if v, err := trie2.GetValue([]byte("HZhouxun")); err != nil || string(v) != "batch value6" {
+		t.Fail()
+	}
+	if err = trie2.Commit(); err != nil {
+		t.Fail()
+	}
+	if _, err = db1.Get(staleRoot); err == nil {
+		fmt.Println("stale root is written to db")
+		t.Fail()
+	}
+
+	// 不使用批量写入的树会把中间节点写入数据库,所以放在另外一个数据库中
+	db2 := db.NewMemoryDB()
+	trie1 := NewMTP(db2)
+	for _, kv := range keyValues {
+		if err = trie1.MustInsert(kv.Key, kv.Value); err != nil {
+			t.Fail()
+		}
+	}
+	if !bytes.Equal(trie1.Root, trie2.Root) || !bytes.Equal(root, trie2.Root) {
+		fmt.Printf("trie1.Root=%s, trie2.Root=%s\n", hex.EncodeToString(trie1.Root), hex.EncodeToString(trie2.Root))
+		t.Fail()
+	}
+
+	trie3 := MTP_Tree(db1, trie2.Root)
+	if v, err := trie3.GetValue([]byte("helloworld")); err != nil || string(v) != "batch value7" {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestDiff(t *testing.T) {
+	db := db.NewMemoryDB()
+	var err error
+	kvA := map[string]string{
+		"HZhouWorld1": "value1", "HZhouxun": "value2", "x": "value3", "helloworld": "value4",
+		"HelloWorld2": "value5", "HelloX": "value6", "zhouxun": "value7", "HZhouWorld2": "value8",
+	}
+	kvB := map[string]string{
+		"HZhouWorld1": "value1", "HZhouxun": "value2 changed", "x": "value3", "helloworld": "value4",
+		"HelloWorld1": "value9", "HelloX": "value6", "HZhu": "value10", "HZhouWorld2": "value8 changed",
+		"abc": "value11",
+	}
+	trieA, trieB := NewMTP(db), NewMTP(db)
+	for k, v := range kvA {
+		trieA.MustInsert([]byte(k), []byte(v))
+	}
+	for k, v := range kvB {
+		trieB.MustInsert([]byte(k), []byte(v))
+	}
+	diff, err := Diff(db, trieA.Root, trieB.Root)
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+		return
+	}
+	added, removed, changed := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, entry := range diff.Added {
+		if kvB[string(entry.Key)] != string(entry.NewValue) {
+			t.Fail()
+		}
+		added = append(added, string(entry.Key))
+	}
+	for _, entry := range diff.Removed {
+		if kvA[string(entry.Key)] != string(entry.OldValue) {
+			t.Fail()
+		}
+		removed = append(removed, string(entry.Key))
+	}
+	for _, entry := range diff.Changed {
+		if kvA[string(entry.Key)] != string(entry.OldValue) || kvB[string(entry.Key)] != string(entry.NewValue) {
+			t.Fail()
+		}
+		changed = append(changed, string(entry.Key))
+	}
+	if fmt.Sprint(added) != "[HZhu HelloWorld1 abc]" || fmt.Sprint(removed) != "[HelloWorld2 zhouxun]" || fmt.Sprint(changed) != "[HZhouWorld2 HZhouxun]" {
+		fmt.Println(added, removed, changed)
+		t.Fail()
+	}
+
+	reverse, err := Diff(db, trieB.Root, trieA.Root)
+	if err != nil || len(reverse.Added) != len(diff.Removed) || len(reverse.Removed) != len(diff.Added) || len(reverse.Changed) != len(diff.Changed) {
+		t.Fail()
+	}
+	same, err := Diff(db, trieA.Root, trieA.Root)
+	if err != nil || len(same.Added)+len(same.Removed)+len(same.Changed) != 0 {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestPrune(t *testing.T) {
+	db1 := db.NewMemoryDB()
+	var err error
+	var keyValues RandomKeyValues = []KeyValue{
+		KeyValue{[]byte("HZhouWorld1"), []byte("prune value1")},
+		KeyValue{[]byte("HZhouxun"), []byte("prune value2")},
+		KeyValue{[]byte("helloworld"), []byte("prune value3")},
+		KeyValue{[]byte("HZhouxun"), []byte("prune value4")},
+	}
+	trie := NewMTP(db1)
+	roots := make([][]byte, 0)
+	for _, kv := range keyValues {
+		if err = trie.MustInsert(kv.Key, kv.Value); err != nil {
+			t.Fail()
+		}
+		roots = append(roots, trie.Root)
+	}
+	// 不是树节点的数据不能被删除
+	blob := []byte(`{"Sons":"not a trie node"}`)
+	blobHash, _ := trie.SaveValue(blob)
+
+	count, err := Prune(db1, [][]byte{roots[1], trie.Root})
+	if err != nil || count == 0 {
+		fmt.Println(count, err)
+		t.Fail()
+	}
+	if data, err := db1.Get(roots[0]); err == nil && len(data) > 0 {
+		fmt.Println("Stale root is not pruned.")
+		t.Fail()
+	}
+	if data, err := db1.Get(blobHash); err != nil || !bytes.Equal(data, blob) {
+		fmt.Println("Blob is pruned.")
+		t.Fail()
+	}
+	for _, root := range [][]byte{roots[1], trie.Root} {
+		tree := MTP_Tree(db1, root)
+		if err = tree.Iterate(nil, nil, func(key, value []byte) bool { return true }); err != nil {
+			fmt.Println(err)
+			t.Fail()
+		}
+	}
+	value, err := trie.GetValue([]byte("HZhouxun"))
+	if err != nil || !bytes.Equal(value, []byte("prune value4")) {
+		t.Fail()
+	}
+	value, err = MTP_Tree(db1, roots[1]).GetValue([]byte("HZhouxun"))
+	if err != nil || !bytes.Equal(value, []byte("prune value2")) {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+// 删除开始时已经不能访问到的节点在删除过程中被重新提交之后不能被删除
+func TestPruneRewrittenNode(t *testing.T) {
+	db1 := db.NewMemoryDB()
+	trie := NewMTP(db1)
+	trie.MustInsert([]byte("HZhouxun"), []byte("prune value1"))
+	staleRoot := trie.Root
+	staleData, _ := db1.Get(staleRoot)
+	trie.MustInsert([]byte("HZhouxun"), []byte("prune value2"))
+
+	count, err := prune(db1, [][]byte{trie.Root}, func() {
+		// 其他区块提交了和旧状态相同的节点,数据库中的数据没有变化
+		if hash, err := MTP_Tree(db1, nil).SaveValue(staleData); err != nil || !bytes.Equal(hash, staleRoot) {
+			t.Fail()
+		}
+	})
+	if err != nil || count == 0 {
+		fmt.Println(count, err)
+		t.Fail()
+	}
+	if data, err := db1.Get(staleRoot); err != nil || !bytes.Equal(data, staleData) {
+		fmt.Println("Rewritten node is pruned.")
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestRLPEncoding(t *testing.T) {
+	db1 := db.NewMemoryDB()
+	var keyValues RandomKeyValues = []KeyValue{
+		KeyValue{[]byte("HZhouWorld1"), []byte("rlp value1")},
+		KeyValue{[]byte("HZhouxun"), []byte("rlp value2")},
+		KeyValue{[]byte("helloworld"), []byte("rlp value3")},
+		KeyValue{[]byte("x"), []byte("rlp value4")},
+	}
+	DefaultEncoding = RLPEncoding
+	defer func() { DefaultEncoding = JSONEncoding }()
+	trie1, trie2 := NewMTP(db1), NewMTP(db1)
+	for i := range keyValues {
+		trie1.MustInsert(keyValues[i].Key, keyValues[i].Value)
+		kv := keyValues[len(keyValues)-1-i]
+		trie2.MustInsert(kv.Key, kv.Value)
+	}
+	if !bytes.Equal(trie1.Root, trie2.Root) {
+		fmt.Println("Root is not deterministic.")
+		t.Fail()
+	}
+	for _, kv := range keyValues {
+		value, err := trie1.GetValue(kv.Key)
+		if err != nil || !bytes.Equal(value, kv.Value) {
+			t.Fail()
+		}
+		proof, err := trie1.GetProof(kv.Key)
+		if err != nil || VerifyProof(trie1.Root, kv.Key, kv.Value, proof) != nil {
+			fmt.Println("Verify rlp proof failed.")
+			t.Fail()
+		}
+	}
+	node, _ := trie1.GetNode(trie1.Root)
+	jsonData, _ := EncodeNode(*node, JSONEncoding)
+	rlpData, _ := EncodeNode(*node, RLPEncoding)
+	if len(rlpData) >= len(jsonData) {
+		t.Fail()
+	}
+
+	// 旧的JSON节点可以继续使用,修改之后的节点使用新的编码
+	trie3 := MTP_Tree(db1, nil)
+	trie3.Encoding = JSONEncoding
+	trie3.Root, _ = trie3.SaveNode(TrieNode{Root: true})
+	for _, kv := range keyValues[:2] {
+		trie3.MustInsert(kv.Key, kv.Value)
+	}
+	trie3.Encoding = RLPEncoding
+	for _, kv := range keyValues[2:] {
+		trie3.MustInsert(kv.Key, kv.Value)
+	}
+	for _, kv := range keyValues {
+		value, err := trie3.GetValue(kv.Key)
+		if err != nil || !bytes.Equal(value, kv.Value) {
+			fmt.Println(string(kv.Key), err)
+			t.Fail()
+		}
+	}
+	fmt.Println("success")
+}
+
+func TestInitEncoding(t *testing.T) {
+	defer func() { DefaultEncoding = JSONEncoding }()
+	// 新的数据库使用配置的编码,之后不能修改
+	db1 := db.NewMemoryDB()
+	if err := InitEncoding(db1, "rlp"); err != nil || DefaultEncoding != RLPEncoding {
+		t.Fail()
+	}
+	if err := InitEncoding(db1, "rlp"); err != nil {
+		t.Fail()
+	}
+	if err := InitEncoding(db1, "json"); err == nil {
+		fmt.Println("encoding changed")
+		t.Fail()
+	}
+
+	// 记录编码之前已经有区块的数据库使用JSON编码
+	db2 := db.NewMemoryDB()
+	db2.Set(db.HeadNamespace.Key([]byte("chain")), []byte("block"))
+	if err := InitEncoding(db2, "rlp"); err == nil {
+		fmt.Println("old database opened with rlp")
+		t.Fail()
+	}
+	if err := InitEncoding(db2, ""); err != nil || DefaultEncoding != JSONEncoding {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+type testSyncPeer struct {
+	db     db.KVStore
+	fails  int // 前fails次请求返回错误
+	limit  int // 成功limit次之后一直返回错误,0表示不限制
+	synced int
+	lock   sync.Mutex
+}
+
+func (peer *testSyncPeer) GetDBValue(key []byte) ([]byte, error) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if peer.fails > 0 || (peer.limit > 0 && peer.synced == peer.limit) {
+		peer.fails--
+		return nil, errors.New("Peer error")
+	}
+	peer.synced++
+	return peer.db.Get(key)
+}
+
+func TestStateSync(t *testing.T) {
+	db1 := db.NewMemoryDB()
+	var err error
+	db2 := db.NewMemoryDB()
+	trie := NewMTP(db1)
+	for i := 0; i < 200; i++ {
+		trie.MustInsert(crypto.Sha3_256([]byte(strconv.Itoa(i))), []byte(fmt.Sprint("sync value", i)))
+	}
+
+	// 同步中断之后从中断的位置继续
+	sync1 := NewStateSync(db2, trie.Root, []SyncPeer{&testSyncPeer{db: db1, limit: 50}})
+	sync1.Workers = 4
+	if err = sync1.Run(); err == nil || sync1.Progress().Synced != 50 {
+		fmt.Println(err, sync1.Progress())
+		t.Fail()
+	}
+	sync2 := NewStateSync(db2, trie.Root, []SyncPeer{&testSyncPeer{db: db1, fails: 5}, &testSyncPeer{db: db1}})
+	if err = sync2.Run(); err != nil || sync2.Progress().Retries == 0 || sync2.Progress().Pending != 0 {
+		fmt.Println(err, sync2.Progress())
+		t.Fail()
+	}
+	count := 0
+	err = MTP_Tree(db2, trie.Root).Iterate(nil, nil, func(key, value []byte) bool {
+		count++
+		return true
+	})
+	if err != nil || count != 200 {
+		fmt.Println(count, err)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestCheckTrie(t *testing.T) {
+	db1 := db.NewMemoryDB()
+	trie := NewMTP(db1)
+	for i := 0; i < 20; i++ {
+		trie.MustInsert(crypto.Sha3_256([]byte(strconv.Itoa(i))), []byte(fmt.Sprint("check value", i)))
+	}
+	if errs := CheckTrie(db1, trie.Root, make(map[string]bool)); len(errs) != 0 {
+		fmt.Println(errs)
+		t.Fail()
+	}
+	root, _ := trie.GetNode(trie.Root)
+	db1.Set(root.Sons[0].Hash, []byte("broken node"))
+	valueHash := crypto.Sha3_256([]byte("check value3"))
+	db1.Delete(valueHash)
+	errs := CheckTrie(db1, trie.Root, make(map[string]bool))
+	if len(errs) != 2 || errs[0].Hash != hex.EncodeToString(root.Sons[0].Hash) || errs[0].Reason != "hash mismatch" {
+		fmt.Println(errs)
+		t.Fail()
+	}
+	if errs[1].Hash != hex.EncodeToString(valueHash) || errs[1].Reason != "missing value" {
+		fmt.Println(errs)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestMTPJournal(t *testing.T) {
+	db1 := db.NewMemoryDB()
+	var err error
+	trie := NewMTP(db1)
+	trie.MustInsert([]byte("HZhouWorld1"), []byte("journal value1"))
+	trie.MustInsert([]byte("HZhouxun"), []byte("journal value2"))
+	root := trie.Root
+
+	// 没有开启批量写入时也可以回滚
+	id := trie.Snapshot()
+	trie.MustInsert([]byte("helloworld"), []byte("journal value0"))
+	if err = trie.RevertToSnapshot(id); err != nil || !bytes.Equal(trie.Root, root) {
+		t.Fail()
+	}
+	if err = trie.Commit(); err != nil {
+		t.Fail()
+	}
+
+	trie.StartBatch()
+	id = trie.Snapshot()
+	trie.MustInsert([]byte("helloworld"), []byte("journal value3"))
+	revertedRoot, _ := trie.Hash()
+	id2 := trie.Snapshot()
+	trie.Delete([]byte("HZhouxun"))
+	if err = trie.RevertToSnapshot(id2); err != nil || !bytes.Equal(trie.Root, revertedRoot) {
+		t.Fail()
+	}
+	if err = trie.RevertToSnapshot(id); err != nil || !bytes.Equal(trie.Root, root) {
+		t.Fail()
+	}
+	// id2在回滚到id之后失效
+	if trie.RevertToSnapshot(id2) != InvalidSnapshotId {
+		t.Fail()
+	}
+	if _, err = trie.GetValue([]byte("helloworld")); err == nil {
+		t.Fail()
+	}
+	id3 := trie.Snapshot()
+	if err = trie.Commit(); err != nil {
+		t.Fail()
+	}
+	// Commit之后快照都会失效
+	if trie.RevertToSnapshot(id3) != InvalidSnapshotId {
+		t.Fail()
+	}
+	if data, err := db1.Get(revertedRoot); err == nil && len(data) > 0 {
+		fmt.Println("Reverted node is written to db.")
+		t.Fail()
+	}
+	value, err := MTP_Tree(db1, trie.Root).GetValue([]byte("HZhouxun"))
+	if err != nil || !bytes.Equal(value, []byte("journal value2")) {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
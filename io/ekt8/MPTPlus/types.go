@@ -31,16 +31,20 @@ type TrieNode struct {
 }
 
 type MTP struct {
-	Lock *sync.RWMutex
-	Root common.HexBytes
-	DB   *db.LevelDB
+	Lock      *sync.RWMutex
+	Root      common.HexBytes
+	DB        db.KVStore
+	Encoding  Encoding
+	batch     *writeBatch
+	batchLock sync.RWMutex
+	journal   []common.HexBytes
 }
 
-func MTP_Tree(db *db.LevelDB, root []byte) *MTP {
-	return &MTP{DB: db, Root: root, Lock: &sync.RWMutex{}}
+func MTP_Tree(db db.KVStore, root []byte) *MTP {
+	return &MTP{DB: db, Root: root, Lock: &sync.RWMutex{}, Encoding: DefaultEncoding}
 }
 
-func NewMTP(db *db.LevelDB) *MTP {
+func NewMTP(db db.KVStore) *MTP {
 	node := TrieNode{
 		Root:      true,
 		Leaf:      false,
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"os"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
+	"github.com/EducationEKT/EKT/io/ekt8/conf"
+	"github.com/EducationEKT/xserver/x_err"
+	"github.com/EducationEKT/xserver/x_http/x_req"
+	"github.com/EducationEKT/xserver/x_http/x_resp"
+	"github.com/EducationEKT/xserver/x_http/x_router"
+)
+
+func init() {
+	x_router.Post("/admin/api/backup", backup)
+}
+
+// 管理接口需要配置文件中的blockchainManagePwd,没有配置时不能使用
+func checkAdmin(req *x_req.XReq) bool {
+	pwd, exist := req.GetParam("pwd")
+	return exist && conf.EKTConfig.BlockchainManagePwd != "" && pwd == conf.EKTConfig.BlockchainManagePwd
+}
+
+// 把节点的数据库备份到节点所在机器上的path,备份包括节点的私钥
+func backup(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	if !checkAdmin(req) {
+		return x_resp.Fail(-403, "permission denied", nil), nil
+	}
+	path := req.MustGetString("path")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	manifest, err := blockchain_manager.GetMainChain().Backup(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return x_resp.Return(manifest, err)
+}
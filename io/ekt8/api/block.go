@@ -4,12 +4,17 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
 	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
 	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
 	"github.com/EducationEKT/EKT/io/ekt8/conf"
 	"github.com/EducationEKT/EKT/io/ekt8/context_log"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/indexer"
 	"github.com/EducationEKT/EKT/io/ekt8/util"
 	"github.com/EducationEKT/xserver/x_err"
 	"github.com/EducationEKT/xserver/x_http/x_req"
@@ -20,7 +25,13 @@ import (
 func init() {
 	x_router.Post("/blocks/api/last", lastBlock)
 	x_router.Get("/block/api/blockByHeight", blockByHeight)
+	x_router.Get("/block/api/byHash", blockByHash)
+	x_router.Get("/block/api/body", blockBody)
 	x_router.Post("/block/api/newBlock", newBlock)
+	x_router.Get("/block/api/statDiff", statDiff)
+	x_router.Get("/block/api/range", blockRange)
+	// 快照的大小和状态的大小相同,不经过x_router缓存整个响应,直接写入ResponseWriter
+	http.HandleFunc("/block/api/snapshot", snapshot)
 }
 
 func lastBlock(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
@@ -38,6 +49,76 @@ func blockByHeight(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	return x_resp.Return(bc.GetBlockByHeight(height))
 }
 
+func blockByHash(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	hash, err := hex.DecodeString(req.MustGetString("hash"))
+	if err != nil {
+		return x_resp.Fail(-1, "error hash", nil), nil
+	}
+	return x_resp.Return(blockchain_manager.GetMainChain().GetBlockByHash(hash))
+}
+
+// 返回解码之后的区块体,包括交易和事件的执行结果
+func blockBody(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	hash, err := hex.DecodeString(req.MustGetString("hash"))
+	if err != nil {
+		return x_resp.Fail(-1, "error hash", nil), nil
+	}
+	bc := blockchain_manager.GetMainChain()
+	block, err := bc.GetBlockByHash(hash)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(bc.GetBlockBody(block))
+}
+
+// 返回from和to两个高度的区块之间账户状态的变化
+func statDiff(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	bc := blockchain_manager.MainBlockChain
+	from, err := bc.GetBlockByHeight(req.MustGetInt64("from"))
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	to, err := bc.GetBlockByHeight(req.MustGetInt64("to"))
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(MPTPlus.Diff(db.GetDBInst(), from.StatRoot, to.StatRoot))
+}
+
+// 下载指定高度的状态快照,新节点导入快照之后从下一个高度开始同步
+func snapshot(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseInt(r.URL.Query().Get("height"), 10, 64)
+	if err != nil {
+		http.Error(w, "error height", http.StatusBadRequest)
+		return
+	}
+	writer := &snapshotWriter{w: w}
+	err = blockchain_manager.GetMainChain().ExportSnapshot(writer, height)
+	if err == nil {
+		return
+	}
+	if !writer.written {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 已经写入了一部分快照,只能断开连接让下载方知道快照不完整
+	panic(http.ErrAbortHandler)
+}
+
+// 第一次写入时才发送响应头,写入之前出错时还可以返回错误码
+type snapshotWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (writer *snapshotWriter) Write(data []byte) (int, error) {
+	if !writer.written {
+		writer.w.Header().Set("Content-Type", "application/octet-stream")
+		writer.written = true
+	}
+	return writer.w.Write(data)
+}
+
 func newBlock(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	cLog := context_log.NewContextLog("Block from peer")
 	defer cLog.Finish()
@@ -46,9 +127,10 @@ func newBlock(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	cLog.Log("block", block)
 	fmt.Printf("Recieved new block : block=%v, blockHash=%s \n", string(block.Bytes()), hex.EncodeToString(block.Hash()))
 	lastHeight := blockchain_manager.GetMainChain().GetLastHeight()
-	if lastHeight+1 != block.Height {
+	// 高度不超过当前高度的区块可能是分支上的区块,由BlockFromPeer根据父区块校验
+	if block.Height <= 0 || block.Height > lastHeight+1 || block.Height <= lastHeight-blockchain.MaxReorgDepth {
 		cLog.Log("Invalid height", true)
-		fmt.Printf("Block height is not right, want %d, get %d, give up voting. \n", lastHeight+1, block.Height)
+		fmt.Printf("Block height is not right, want at most %d, get %d, give up voting. \n", lastHeight+1, block.Height)
 		return x_resp.Fail(-1, "error invalid height", nil), nil
 	}
 	IP := strings.Split(req.R.RemoteAddr, ":")[0]
@@ -69,3 +151,12 @@ func newBlock(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	blockchain_manager.MainBlockChainConsensus.BlockFromPeer(cLog, block)
 	return x_resp.Return("recieved", nil)
 }
+
+// 查询from到to之间的区块,需要开启索引
+func blockRange(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	inst := indexer.GetInst()
+	if inst == nil {
+		return x_resp.Return(nil, indexer.NotEnabled)
+	}
+	return x_resp.Return(inst.BlockRange(req.MustGetInt64("from"), req.MustGetInt64("to")))
+}
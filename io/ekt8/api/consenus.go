@@ -2,6 +2,10 @@ package api
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
 	"github.com/EducationEKT/EKT/io/ekt8/p2p"
 	"github.com/EducationEKT/EKT/io/ekt8/util"
 	"github.com/EducationEKT/xserver/x_err"
@@ -15,6 +19,63 @@ import (
 */
 func init() {
 	x_router.Post("/consenus/api/receive", receive)
+	x_router.Get("/consenus/api/candidates", candidates)
+	x_router.Get("/consenus/api/delegates", delegates)
+	x_router.Get("/consenus/api/validatorChanges", validatorChanges)
+	x_router.Get("/consenus/api/slot", currentSlot)
+	x_router.Get("/consenus/api/missedSlots", missedSlots)
+}
+
+// 所有候选节点和得到的投票
+func candidates(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	return x_resp.Return(blockchain_manager.GetMainChain().GetLastBlock().Candidates())
+}
+
+// 当前轮次出块的节点,以及按照当前状态下一轮会当选的节点
+func delegates(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	bc := blockchain_manager.GetMainChain()
+	round, err := bc.CurrentRound()
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	elected, err := bc.GetLastBlock().Delegates(blockchain.DelegateCount)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(map[string]interface{}{
+		"current": round.Peers,
+		"elected": elected,
+	}, nil)
+}
+
+// 治理账户安排的出块节点变更,包括已经生效的变更
+func validatorChanges(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	return x_resp.Return(blockchain_manager.GetMainChain().GetLastBlock().ValidatorChanges())
+}
+
+// 当前时间所在的出块时间槽
+func currentSlot(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	return x_resp.Return(blockchain_manager.GetMainChain().CurrentSlot(time.Now().UnixNano() / 1e6))
+}
+
+// 高度在from到to之间的区块之前错过出块的节点,以及每个节点错过的总数,默认是所有区块
+func missedSlots(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	bc := blockchain_manager.GetMainChain()
+	from, to := int64(1), bc.GetLastHeight()
+	if _, exist := req.GetParam("from"); exist {
+		from = req.MustGetInt64("from")
+	}
+	if _, exist := req.GetParam("to"); exist {
+		to = req.MustGetInt64("to")
+	}
+	slots, err := bc.GetMissedSlots(from, to)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(map[string]interface{}{
+		"slots":     slots,
+		"delegates": blockchain.CountMissedSlots(slots),
+	}, nil)
 }
 
 func receive(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
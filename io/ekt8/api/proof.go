@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/hex"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+// 返回key在root对应的树上的proof,key不存在时value为空,proof可以用来证明key不存在
+func merkleProof(height int64, root, key []byte) (map[string]interface{}, error) {
+	tree := MPTPlus.MTP_Tree(db.GetDBInst(), root)
+	proof, err := tree.GetProof(key)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := tree.GetValue(key)
+	return map[string]interface{}{
+		"height": height,
+		"root":   hex.EncodeToString(root),
+		"key":    hex.EncodeToString(key),
+		"value":  hex.EncodeToString(value),
+		"exist":  value != nil,
+		"proof":  proof,
+	}, nil
+}
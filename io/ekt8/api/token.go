@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/hex"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/xserver/x_err"
+	"github.com/EducationEKT/xserver/x_http/x_req"
+	"github.com/EducationEKT/xserver/x_http/x_resp"
+	"github.com/EducationEKT/xserver/x_http/x_router"
+)
+
+func init() {
+	x_router.Get("/token/api/info", tokenInfo)
+	x_router.Get("/token/api/list", tokenList)
+}
+
+type TokenInfo struct {
+	Address common.HexBytes `json:"address"`
+	common.Token
+}
+
+func tokenInfo(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	address, err := hex.DecodeString(req.MustGetString("address"))
+	if err != nil {
+		return x_resp.Fail(-1, "error address", nil), nil
+	}
+	token, err := blockchain_manager.GetMainChain().GetLastBlock().GetToken(address)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(TokenInfo{Address: address, Token: *token}, nil)
+}
+
+// 返回当前区块中已经发行的所有token
+func tokenList(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	tokens, err := blockchain_manager.GetMainChain().GetLastBlock().Tokens()
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	infos := make([]TokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		infos = append(infos, TokenInfo{Address: token.Address(), Token: token})
+	}
+	return x_resp.Return(infos, nil)
+}
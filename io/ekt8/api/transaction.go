@@ -1,15 +1,18 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
 
 	"fmt"
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
 	"github.com/EducationEKT/EKT/io/ekt8/conf"
 	"github.com/EducationEKT/EKT/io/ekt8/context_log"
 	"github.com/EducationEKT/EKT/io/ekt8/core/common"
 	"github.com/EducationEKT/EKT/io/ekt8/crypto"
 	"github.com/EducationEKT/EKT/io/ekt8/db"
 	"github.com/EducationEKT/EKT/io/ekt8/dispatcher"
+	"github.com/EducationEKT/EKT/io/ekt8/indexer"
 	"github.com/EducationEKT/EKT/io/ekt8/param"
 	"github.com/EducationEKT/EKT/io/ekt8/util"
 	"github.com/EducationEKT/xserver/x_err"
@@ -21,6 +24,9 @@ import (
 
 func init() {
 	x_router.Post("/transaction/api/newTransaction", broadcastTx, newTransaction)
+	x_router.Get("/transaction/api/proof", txProof)
+	x_router.Get("/transaction/api/get", getTransaction)
+	x_router.Get("/transaction/api/byAddress", txsByAddress)
 }
 
 func newTransaction(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
@@ -33,7 +39,7 @@ func newTransaction(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	if err != nil {
 		return nil, x_err.New(-1, err.Error())
 	}
-	if tx.Amount <= 0 {
+	if tx.Amount <= 0 && !tx.IsIssueToken() && !tx.IsElection() {
 		return nil, x_err.New(-100, "error amount")
 	}
 	err = dispatcher.NewTransaction(log, &tx)
@@ -63,3 +69,40 @@ func broadcastTx(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	}
 	return nil, nil
 }
+
+func txProof(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	txId, err := hex.DecodeString(req.MustGetString("txId"))
+	if err != nil {
+		return x_resp.Fail(-1, "error txId", nil), nil
+	}
+	block, err := blockchain_manager.GetMainChain().GetBlockByHeight(req.MustGetInt64("height"))
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(merkleProof(block.Height, block.TxRoot, txId))
+}
+
+// 查询已经写入区块的交易,返回签名的交易、执行结果和所在的区块
+func getTransaction(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	txId, err := hex.DecodeString(req.MustGetString("txId"))
+	if err != nil {
+		return x_resp.Fail(-1, "error txId", nil), nil
+	}
+	return x_resp.Return(blockchain_manager.GetMainChain().GetTxReceipt(txId))
+}
+
+// 查询和地址相关的交易,需要开启索引
+func txsByAddress(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	inst := indexer.GetInst()
+	if inst == nil {
+		return x_resp.Return(nil, indexer.NotEnabled)
+	}
+	page, size := 1, indexer.DefaultPageSize
+	if _, exist := req.GetParam("page"); exist {
+		page = int(req.MustGetInt64("page"))
+	}
+	if _, exist := req.GetParam("size"); exist {
+		size = int(req.MustGetInt64("size"))
+	}
+	return x_resp.Return(inst.TxsByAddress(req.MustGetString("address"), page, size))
+}
@@ -12,6 +12,7 @@ import (
 
 func init() {
 	x_router.Get("/user/api/info", userInfo)
+	x_router.Get("/user/api/proof", userProof)
 }
 
 func userInfo(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
@@ -28,3 +29,12 @@ func userInfo(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	}
 	return x_resp.Return(account, nil)
 }
+
+func userProof(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	address, err := hex.DecodeString(req.MustGetString("address"))
+	if err != nil {
+		return x_resp.Fail(-1, "error address", nil), nil
+	}
+	block := blockchain_manager.GetMainChain().GetLastBlock()
+	return x_resp.Return(merkleProof(block.Height, block.StatRoot, address))
+}
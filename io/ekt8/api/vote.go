@@ -41,7 +41,7 @@ func voteResult(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 		fmt.Println("Invalid vote, unmarshal fail, abort.")
 		return x_resp.Return(nil, err)
 	}
-	blockchain_manager.GetMainChainConsensus().RecieveVoteResult(votes)
+	blockchain_manager.GetMainChainConsensus().Finalize(votes)
 	return x_resp.Success(make(map[string]interface{})), nil
 }
 
@@ -0,0 +1,215 @@
+package blockchain
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+const (
+	BackupVersion = 1
+	// 备份文件中的两个文件,manifest在前
+	backupManifestName = "manifest.json"
+	backupDataName     = "data"
+	// 恢复时每写入多少个key写一次数据库
+	backupBatchSize = 10000
+)
+
+var InvalidBackup = errors.New("Invalid backup")
+
+type BackupManifest struct {
+	Version       int             `json:"version"`
+	SchemaVersion int             `json:"schemaVersion"`
+	ChainId       common.HexBytes `json:"chainId"`
+	Height        int64           `json:"height"`
+	BlockHash     common.HexBytes `json:"blockHash"`
+	Keys          int64           `json:"keys"`
+	Time          int64           `json:"time"`
+}
+
+/*
+*备份数据库中的所有数据,包括区块、状态、投票和节点的私钥,节点不需要停止
+*
+*遍历的是数据库的快照,manifest中的当前区块和备份的数据一致
+*备份是tar.gz格式,包括manifest.json和data,data中每条记录是key的长度 + key + value的长度 + value
+ */
+func (blockchain *BlockChain) Backup(w io.Writer) (*BackupManifest, error) {
+	tmp, err := ioutil.TempFile("", "ekt-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	manifest := &BackupManifest{Version: BackupVersion, ChainId: blockchain.ChainId, Time: time.Now().UnixNano() / 1e6}
+	headKey := blockchain.CurrentBlockKey()
+	writer := bufio.NewWriter(tmp)
+	iter := blockchain.DB.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err = writeBytes(writer, iter.Key()); err == nil {
+			err = writeBytes(writer, iter.Value())
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Keys++
+		if bytes.Equal(iter.Key(), headKey) {
+			var head Block
+			if err = json.Unmarshal(iter.Value(), &head); err != nil {
+				return nil, err
+			}
+			manifest.Height, manifest.BlockHash = head.Height, head.CurrentHash
+		} else if bytes.Equal(iter.Key(), db.SchemaVersionKey) {
+			manifest.SchemaVersion, _ = strconv.Atoi(string(iter.Value()))
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return nil, err
+	}
+	if len(manifest.BlockHash) == 0 {
+		return nil, errors.New("Current block not found")
+	}
+	if err = writer.Flush(); err != nil {
+		return nil, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	manifestData, _ := json.Marshal(manifest)
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	if err = tarWriter.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(manifestData))}); err != nil {
+		return nil, err
+	}
+	if _, err = tarWriter.Write(manifestData); err != nil {
+		return nil, err
+	}
+	if err = tarWriter.WriteHeader(&tar.Header{Name: backupDataName, Mode: 0600, Size: size}); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(tarWriter, tmp); err != nil {
+		return nil, err
+	}
+	if err = tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, gzipWriter.Close()
+}
+
+/*
+*把备份恢复到空的数据库中,恢复之后检查当前区块和manifest是否一致
+*
+*恢复失败时删除已经写入的数据,数据库保持为空
+ */
+func RestoreBackup(store db.KVStore, r io.Reader) (manifest *BackupManifest, err error) {
+	if !db.IsEmpty(store) {
+		return nil, errors.New("Database is not empty")
+	}
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	header, err := tarReader.Next()
+	if err != nil || header.Name != backupManifestName {
+		return nil, InvalidBackup
+	}
+	if err = json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version != BackupVersion {
+		return nil, InvalidBackup
+	}
+	if header, err = tarReader.Next(); err != nil || header.Name != backupDataName {
+		return nil, InvalidBackup
+	}
+
+	defer func() {
+		if err != nil {
+			clearStore(store)
+		}
+	}()
+	reader := bufio.NewReader(tarReader)
+	batch := store.NewBatch()
+	var keys int64
+	for {
+		key, err := readBytes(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		batch.Put(key, value)
+		keys++
+		if batch.Len() >= backupBatchSize {
+			if err = store.WriteBatch(batch); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+	}
+	if err = store.WriteBatch(batch); err != nil {
+		return nil, err
+	}
+	if keys != manifest.Keys {
+		return nil, errors.New("Key count mismatch")
+	}
+	if err = manifest.verify(store); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// 检查恢复之后的CurrentBlockKey是否和manifest一致
+func (manifest *BackupManifest) verify(store db.KVStore) error {
+	if version, err := db.GetSchemaVersion(store); err != nil || version != manifest.SchemaVersion {
+		return errors.New("Schema version mismatch")
+	}
+	data, err := store.Get(db.HeadNamespace.Key(manifest.ChainId))
+	if err != nil {
+		return errors.New("Current block not found")
+	}
+	var head Block
+	if err = json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	if head.Height != manifest.Height || !bytes.Equal(head.CurrentHash, manifest.BlockHash) || !bytes.Equal(head.CaculateHash(), manifest.BlockHash) {
+		return errors.New("Current block mismatch")
+	}
+	if hashData, err := store.Get(head.CurrentHash); err != nil || !bytes.Equal(hashData, head.Data()) {
+		return errors.New("Current block mismatch")
+	}
+	return nil
+}
+
+func clearStore(store db.KVStore) {
+	iter := store.NewIterator(nil)
+	defer iter.Release()
+	batch := store.NewBatch()
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	store.WriteBatch(batch)
+}
@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	store := db.NewMemoryDB()
+	db.Migrate(store)
+	chain := NewBlockChain(store, BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	block := &Block{
+		Height:    1,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{CurrentIndex: 0},
+		store:     store,
+	}
+	block.StatTree.MustInsert([]byte("account"), []byte("value"))
+	block.UpdateMPTPlusRoot()
+	block.CaculateHash()
+	chain.SaveBlock(block, nil)
+
+	buffer := bytes.Buffer{}
+	manifest, err := chain.Backup(&buffer)
+	if err != nil || manifest.Height != 1 || !bytes.Equal(manifest.BlockHash, block.Hash()) || manifest.SchemaVersion != db.SchemaVersion {
+		fmt.Println(manifest, err)
+		t.FailNow()
+	}
+	data := buffer.Bytes()
+	restored := db.NewMemoryDB()
+	if _, err = RestoreBackup(restored, bytes.NewReader(data)); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	value, err := MPTPlus.MTP_Tree(restored, block.StatRoot).GetValue([]byte("account"))
+	if err != nil || string(value) != "value" {
+		t.Fail()
+	}
+	// 只能恢复到空的数据库
+	if _, err = RestoreBackup(restored, bytes.NewReader(data)); err == nil {
+		t.Fail()
+	}
+
+	// 备份之后修改了当前区块,和manifest不一致
+	block.Height = 2
+	store.Set(chain.CurrentBlockKey(), block.Bytes())
+	buffer.Reset()
+	chain.Backup(&buffer)
+	empty := db.NewMemoryDB()
+	if _, err = RestoreBackup(empty, &buffer); err == nil || !db.IsEmpty(empty) {
+		fmt.Println("inconsistent backup restored", err)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -20,8 +20,6 @@ import (
 	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
 )
 
-var currentBlock *Block = nil
-
 type Block struct {
 	Height       int64              `json:"height"`
 	Timestamp    int64              `json:"timestamp"`
@@ -43,6 +41,15 @@ type Block struct {
 	EventRoot    common.HexBytes    `json:"eventRoot"`
 	TokenTree    *MPTPlus.MTP       `json:"-"`
 	TokenRoot    common.HexBytes    `json:"tokenRoot"`
+	store        db.KVStore
+}
+
+// 区块的树使用的数据库,从网络上收到的区块使用节点的数据库
+func (block *Block) DB() db.KVStore {
+	if block.store == nil {
+		return db.GetDBInst()
+	}
+	return block.store
 }
 
 func (block Block) GetRound() *i_consensus.Round {
@@ -83,7 +90,7 @@ func (block *Block) NewNonce() {
 
 func (block *Block) GetAccount(log *context_log.ContextLog, address []byte) (*common.Account, error) {
 	if block.StatTree == nil {
-		block.StatTree = MPTPlus.MTP_Tree(db.GetDBInst(), block.StatRoot)
+		block.StatTree = MPTPlus.MTP_Tree(block.DB(), block.StatRoot)
 	}
 	value, err := block.StatTree.GetValue(address)
 	if err != nil {
@@ -99,7 +106,7 @@ func (block *Block) GetAccount(log *context_log.ContextLog, address []byte) (*co
 
 func (block *Block) ExistAddress(address []byte) bool {
 	if block.StatTree == nil {
-		block.StatTree = MPTPlus.MTP_Tree(db.GetDBInst(), block.StatRoot)
+		block.StatTree = MPTPlus.MTP_Tree(block.DB(), block.StatRoot)
 	}
 	return block.StatTree.ContainsKey(address)
 }
@@ -123,11 +130,10 @@ func (block *Block) InsertAccount(account common.Account) bool {
 	return false
 }
 
-func (block *Block) newAccount(address []byte, pubKey []byte) {
+func (block *Block) newAccount(address []byte, pubKey []byte) error {
 	account := common.NewAccount(address, pubKey)
 	value, _ := json.Marshal(account)
-	block.StatTree.MustInsert(address, value)
-	block.UpdateMPTPlusRoot()
+	return block.StatTree.MustInsert(address, value)
 }
 
 func (block *Block) NewTransaction(log *context_log.ContextLog, tx *common.Transaction, fee int64) *common.TxResult {
@@ -139,24 +145,34 @@ func (block *Block) NewTransaction(log *context_log.ContextLog, tx *common.Trans
 		account_ := common.CreateAccount(hex.EncodeToString(toAddress), 0)
 		recieverAccount = &account_
 	}
+	// 转账给自己时两个账户是同一个对象,否则后写入的账户会覆盖先写入的账户
+	if bytes.Equal(fromAddress, toAddress) {
+		recieverAccount = account
+	}
 	log.Log("from", account)
 	log.Log("to", recieverAccount)
 	var txResult *common.TxResult
 	if fee < block.Fee {
 		log.Log("fee<block.Fee", true)
-		return common.NewTransactionResult(tx, fee, false, "fee is too less")
+		return common.NewTransactionResult(tx, 0, false, "fee is too less")
 	}
-	if tx.Nonce != account.Nonce+1 {
+	if account == nil {
+		txResult = common.NewTransactionResult(tx, fee, false, "account not exist")
+	} else if IsReservedAddress(toAddress) {
+		txResult = common.NewTransactionResult(tx, fee, false, "reserved address")
+	} else if tx.Nonce != account.Nonce+1 {
 		txResult = common.NewTransactionResult(tx, fee, false, "invalid nonce")
+	} else if tx.IsElection() {
+		txResult = block.election(tx, fee, fromAddress, account)
+	} else if tx.IsIssueToken() {
+		txResult = block.issueToken(tx, fee, fromAddress, account)
 	} else if tx.TokenAddress == "" {
 		if account.GetAmount() < tx.Amount+fee {
 			txResult = common.NewTransactionResult(tx, fee, false, "no enough gas")
 		} else {
-			account.ReduceAmount(tx.Amount)
+			account.ReduceAmount(tx.Amount + fee)
 			recieverAccount.AddAmount(tx.Amount)
-			block.StatTree.MustInsert(fromAddress, account.ToBytes())
-			block.StatTree.MustInsert(toAddress, recieverAccount.ToBytes())
-			txResult = common.NewTransactionResult(tx, fee, true, "")
+			txResult = block.updateAccounts(tx, fee, fromAddress, toAddress, account, recieverAccount)
 		}
 	} else {
 		if account.Balances[tx.TokenAddress] < tx.Amount {
@@ -171,56 +187,242 @@ func (block *Block) NewTransaction(log *context_log.ContextLog, tx *common.Trans
 				recieverAccount.Balances[tx.TokenAddress] = 0
 			}
 			recieverAccount.Balances[tx.TokenAddress] += tx.Amount
-			block.StatTree.MustInsert(fromAddress, account.ToBytes())
-			block.StatTree.MustInsert(toAddress, recieverAccount.ToBytes())
-			txResult = common.NewTransactionResult(tx, fee, true, "")
+			txResult = block.updateAccounts(tx, fee, fromAddress, toAddress, account, recieverAccount)
 		}
 	}
+	// 失败的交易不收手续费,记录的手续费和实际支付的一致
+	if txResult.Success {
+		block.TotalFee += fee
+	} else {
+		txResult.Fee = 0
+	}
 	log.Log("txId", tx.TransactionId())
 	log.Log("txResult", txResult)
 	txId, _ := hex.DecodeString(tx.TransactionId())
 	block.TxTree.MustInsert(txId, txResult.ToBytes())
-	block.UpdateMPTPlusRoot()
 	return txResult
 }
 
+/*
+*发行token,把Token写入TokenTree,发行的总量转入发行人的账户
+*
+*token的名字不能和已经发行的token重复,发行人需要支付手续费
+ */
+func (block *Block) issueToken(tx *common.Transaction, fee int64, fromAddress []byte, account *common.Account) *common.TxResult {
+	token, err := tx.IssuedToken()
+	if err != nil || tx.Amount != 0 {
+		return common.NewTransactionResult(tx, fee, false, "invalid token")
+	}
+	if account.GetAmount() < fee {
+		return common.NewTransactionResult(tx, fee, false, "no enough gas")
+	}
+	if _, err := block.GetTokenByName(token.Name); err == nil {
+		return common.NewTransactionResult(tx, fee, false, "duplicate token name")
+	}
+	account.ReduceAmount(fee)
+	if account.Balances == nil {
+		account.Balances = make(map[string]int64)
+	}
+	tokenAddress := token.Address()
+	account.Balances[hex.EncodeToString(tokenAddress)] += token.Total
+	snapshot := block.Snapshot()
+	if block.TokenTree.MustInsert(tokenAddress, token.Bytes()) != nil || block.TokenTree.MustInsert(TokenNameKey(token.Name), tokenAddress) != nil ||
+		block.StatTree.MustInsert(fromAddress, account.ToBytes()) != nil {
+		block.RevertToSnapshot(snapshot)
+		return common.NewTransactionResult(tx, fee, false, "update account failed")
+	}
+	return common.NewTransactionResult(tx, fee, true, "")
+}
+
+func (block *Block) GetToken(address []byte) (*common.Token, error) {
+	if block.TokenTree == nil {
+		block.TokenTree = MPTPlus.MTP_Tree(block.DB(), block.TokenRoot)
+	}
+	var token common.Token
+	if err := block.TokenTree.GetInterfaceValue(address, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+const tokenNamePrefix = "name:"
+
+/*
+*TokenTree中token名字的索引,value是token的地址
+*
+*索引的key比token的地址多一个前缀,不会和token的地址冲突,遍历token时跳过索引
+ */
+func TokenNameKey(name string) []byte {
+	return append([]byte(tokenNamePrefix), crypto.Sha3_256([]byte(name))...)
+}
+
+func isTokenNameKey(key []byte) bool {
+	return len(key) == len(tokenNamePrefix)+32 && bytes.HasPrefix(key, []byte(tokenNamePrefix))
+}
+
+func (block *Block) GetTokenByName(name string) (*common.Token, error) {
+	if block.TokenTree == nil {
+		block.TokenTree = MPTPlus.MTP_Tree(block.DB(), block.TokenRoot)
+	}
+	address, err := block.TokenTree.GetValue(TokenNameKey(name))
+	if err != nil {
+		return nil, err
+	}
+	return block.GetToken(address)
+}
+
+// 已经发行的所有token,按照token的地址排序
+func (block *Block) Tokens() ([]common.Token, error) {
+	if block.TokenTree == nil {
+		block.TokenTree = MPTPlus.MTP_Tree(block.DB(), block.TokenRoot)
+	}
+	tokens := make([]common.Token, 0)
+	var err error
+	iterErr := block.TokenTree.Iterate(nil, nil, func(key, value []byte) bool {
+		if isTokenNameKey(key) {
+			return true
+		}
+		var token common.Token
+		if err = json.Unmarshal(value, &token); err != nil {
+			return false
+		}
+		tokens = append(tokens, token)
+		return true
+	})
+	if iterErr != nil {
+		return nil, iterErr
+	}
+	return tokens, err
+}
+
+/*
+*把区块中所有交易的手续费转给打包节点的AccountAddress,打包和校验区块时在执行完所有交易之后调用
+*
+*打包节点没有配置AccountAddress时手续费不转给任何账户
+ */
+func (block *Block) PayFee() {
+	round := block.GetRound()
+	if block.TotalFee == 0 || round == nil || round.CurrentIndex < 0 || round.CurrentIndex >= len(round.Peers) {
+		return
+	}
+	address, err := hex.DecodeString(round.Peers[round.CurrentIndex].AccountAddress)
+	if err != nil || len(address) == 0 {
+		return
+	}
+	account, err := block.GetAccount(nil, address)
+	if err != nil || account == nil {
+		account_ := common.CreateAccount(hex.EncodeToString(address), 0)
+		account = &account_
+	}
+	account.AddAmount(block.TotalFee)
+	block.StatTree.MustInsert(address, account.ToBytes())
+}
+
+// 同时写入交易双方的账户,任何一个账户写入失败时回滚,保证交易要么全部生效要么全部不生效
+func (block *Block) updateAccounts(tx *common.Transaction, fee int64, fromAddress, toAddress []byte, from, to *common.Account) *common.TxResult {
+	snapshot := block.StatTree.Snapshot()
+	if block.StatTree.MustInsert(fromAddress, from.ToBytes()) != nil || block.StatTree.MustInsert(toAddress, to.ToBytes()) != nil {
+		block.StatTree.RevertToSnapshot(snapshot)
+		return common.NewTransactionResult(tx, fee, false, "update account failed")
+	}
+	return common.NewTransactionResult(tx, fee, true, "")
+}
+
+// 批量写入时会计算改变过的节点的Hash,计算失败时保留原来的Root,Commit时会返回同样的错误
 func (block *Block) UpdateMPTPlusRoot() {
 	if block.StatTree != nil {
-		block.StatTree.Lock.RLock()
-		block.StatRoot = block.StatTree.Root
-		block.StatTree.Lock.RUnlock()
+		if root, err := block.StatTree.Hash(); err == nil {
+			block.StatRoot = root
+		}
 	}
 	if block.TxTree != nil {
-		block.TxTree.Lock.RLock()
-		block.TxRoot = block.TxTree.Root
-		block.TxTree.Lock.RUnlock()
+		if root, err := block.TxTree.Hash(); err == nil {
+			block.TxRoot = root
+		}
 	}
 	if block.EventTree != nil {
-		block.EventTree.Lock.RLock()
-		block.EventRoot = block.EventTree.Root
-		block.EventTree.Lock.RUnlock()
+		if root, err := block.EventTree.Hash(); err == nil {
+			block.EventRoot = root
+		}
 	}
 	if block.TokenTree != nil {
-		block.TokenTree.Lock.RLock()
-		block.TokenRoot = block.TokenTree.Root
-		block.TokenTree.Lock.RUnlock()
+		if root, err := block.TokenTree.Hash(); err == nil {
+			block.TokenRoot = root
+		}
 	}
 }
 
+// 打包和校验区块时对树的修改先保存在内存中,在Commit时一次性写入数据库
+func (block *Block) StartBatch() {
+	for _, tree := range block.trees() {
+		tree.StartBatch()
+	}
+}
+
+func (block *Block) Commit() error {
+	for _, tree := range block.trees() {
+		if err := tree.Commit(); err != nil {
+			return err
+		}
+	}
+	block.UpdateMPTPlusRoot()
+	return nil
+}
+
+// 保存四棵树当前的状态,返回每棵树的快照id
+func (block *Block) Snapshot() []int {
+	trees := block.trees()
+	ids := make([]int, 0, len(trees))
+	for _, tree := range trees {
+		ids = append(ids, tree.Snapshot())
+	}
+	return ids
+}
+
+func (block *Block) RevertToSnapshot(ids []int) error {
+	trees := block.trees()
+	if len(ids) != len(trees) {
+		return MPTPlus.InvalidSnapshotId
+	}
+	for i, tree := range trees {
+		if err := tree.RevertToSnapshot(ids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (block *Block) trees() []*MPTPlus.MTP {
+	trees := make([]*MPTPlus.MTP, 0, 4)
+	for _, tree := range []*MPTPlus.MTP{block.StatTree, block.TxTree, block.EventTree, block.TokenTree} {
+		if tree != nil {
+			trees = append(trees, tree)
+		}
+	}
+	return trees
+}
+
 func FromBytes2Block(data []byte) (*Block, error) {
+	return fromBytes2Block(db.GetDBInst(), data)
+}
+
+func fromBytes2Block(store db.KVStore, data []byte) (*Block, error) {
 	var block Block
 	err := json.Unmarshal(data, &block)
 	if err != nil {
 		return nil, err
 	}
-	block.EventTree = MPTPlus.MTP_Tree(db.GetDBInst(), block.EventRoot)
-	block.StatTree = MPTPlus.MTP_Tree(db.GetDBInst(), block.StatRoot)
-	block.TxTree = MPTPlus.MTP_Tree(db.GetDBInst(), block.TxRoot)
+	block.store = store
+	block.EventTree = MPTPlus.MTP_Tree(store, block.EventRoot)
+	block.StatTree = MPTPlus.MTP_Tree(store, block.StatRoot)
+	block.TxTree = MPTPlus.MTP_Tree(store, block.TxRoot)
+	block.TokenTree = MPTPlus.MTP_Tree(store, block.TokenRoot)
 	block.Locker = sync.RWMutex{}
 	return &block, nil
 }
 
 func NewBlock(last *Block, newRound *i_consensus.Round) *Block {
+	store := last.DB()
 	block := &Block{
 		Height:       last.Height + 1,
 		Nonce:        0,
@@ -233,11 +435,13 @@ func NewBlock(last *Block, newRound *i_consensus.Round) *Block {
 		Body:         nil,
 		Round:        newRound,
 		Locker:       sync.RWMutex{},
-		StatTree:     MPTPlus.MTP_Tree(db.GetDBInst(), last.StatRoot),
-		TxTree:       MPTPlus.NewMTP(db.GetDBInst()),
-		EventTree:    MPTPlus.NewMTP(db.GetDBInst()),
-		TokenTree:    MPTPlus.MTP_Tree(db.GetDBInst(), last.TokenRoot),
+		StatTree:     MPTPlus.MTP_Tree(store, last.StatRoot),
+		TxTree:       MPTPlus.NewMTP(store),
+		EventTree:    MPTPlus.NewMTP(store),
+		TokenTree:    MPTPlus.MTP_Tree(store, last.TokenRoot),
+		store:        store,
 	}
+	block.StartBatch()
 	return block
 }
 
@@ -281,7 +485,7 @@ func (block *Block) ValidateBlockStat(next Block) bool {
 	//让新生成的区块执行peer传过来的body中的events进行计算
 	for _, eventResult := range next.BlockBody.EventResults {
 		evtId, _ := hex.DecodeString(eventResult.EventId)
-		evt := event.GetEvent(evtId)
+		evt := event.GetEvent(block.DB(), evtId)
 		if evt == nil {
 			data, err := next.GetRound().Peers[next.GetRound().CurrentIndex].GetDBValue(evtId)
 			if err != nil {
@@ -302,7 +506,7 @@ func (block *Block) ValidateBlockStat(next Block) bool {
 	defer cLog.Finish()
 	for _, txResult := range next.BlockBody.TxResults {
 		txId, _ := hex.DecodeString(txResult.TxId)
-		tx := common.GetTransaction(txId)
+		tx := common.GetTransaction(block.DB(), txId)
 		if tx == nil {
 			data, err := next.GetRound().Peers[next.GetRound().CurrentIndex].GetDBValue(txId)
 			if err != nil {
@@ -310,15 +514,19 @@ func (block *Block) ValidateBlockStat(next Block) bool {
 				return false
 			}
 			tx = common.FromBytes(data)
-			if tx == nil {
+			if tx == nil || tx.TransactionId() != txResult.TxId {
 				fmt.Println("Can not get this transaction, validate false.")
 				return false
 			}
+			// 保存从其他节点获取的交易,查询交易和建立索引时使用
+			block.DB().Set(txId, tx.Bytes())
 		}
 		_next.NewTransaction(cLog, tx, block.Fee)
 	}
+	_next.PayFee()
 	_next.UpdateMPTPlusRoot()
-	if !bytes.Equal(next.TxRoot, _next.TxRoot) ||
+	if next.TotalFee != _next.TotalFee ||
+		!bytes.Equal(next.TxRoot, _next.TxRoot) ||
 		!bytes.Equal(next.EventRoot, _next.EventRoot) ||
 		!bytes.Equal(next.StatRoot, _next.StatRoot) ||
 		!bytes.Equal(next.TokenRoot, _next.TokenRoot) {
@@ -326,26 +534,34 @@ func (block *Block) ValidateBlockStat(next Block) bool {
 		fmt.Printf("next.Hash  = %s, \n_next.Hash = %s \n", hex.EncodeToString(next.Hash()), hex.EncodeToString(_next.CaculateHash()))
 		return false
 	}
+	if err := _next.Commit(); err != nil {
+		fmt.Println("Write block stat to database failed.", err)
+		return false
+	}
 
 	BlockRecorder.SetStatus(hex.EncodeToString(next.CurrentHash), 100)
 	return true
 }
 
+// 事件对状态的修改失败时回滚到执行事件之前的状态,失败的结果同样写入EventTree
 func (block *Block) HandlerEvent(evt *event.Event) event.EventResult {
 	evtResult := event.EventResult{
 		EventId: hex.EncodeToString(evt.EventId()),
 		Success: false,
 		Reason:  "",
 	}
+	snapshot := block.Snapshot()
 	if evt.EventType == event.NewAccountEvent {
 		param := evt.EventParam.(event.NewAccountParam)
 		address, _ := hex.DecodeString(param.Address)
 		pubKey, _ := hex.DecodeString(param.PubKey)
-		if !block.ExistAddress(address) {
-			block.newAccount(address, pubKey)
-			evtResult.Success = true
-		} else {
+		if block.ExistAddress(address) {
 			evtResult.Reason = "AddressExist"
+		} else if err := block.newAccount(address, pubKey); err != nil {
+			block.RevertToSnapshot(snapshot)
+			evtResult.Reason = "create account failed"
+		} else {
+			evtResult.Success = true
 		}
 	}
 	block.EventTree.MustInsert(evt.EventId(), evtResult.Bytes())
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/context_log"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+func TestTransactionFee(t *testing.T) {
+	store := db.NewMemoryDB()
+	from, to, producer := "01", "02", "03"
+	block := &Block{
+		Height:    1,
+		Fee:       10,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{Peers: p2p.Peers{{AccountAddress: producer}}, CurrentIndex: 0},
+		store:     store,
+	}
+	sender := common.CreateAccount(from, 1000)
+	sender.Balances = map[string]int64{"token": 50}
+	block.InsertAccount(sender)
+
+	cLog := context_log.NewContextLog("test")
+	txs := []*common.Transaction{
+		{From: from, To: to, Amount: 100, Nonce: 1},
+		{From: from, To: to, Amount: 20, Nonce: 2, TokenAddress: "token"},
+		// nonce不对的交易不收手续费
+		{From: from, To: to, Amount: 100, Nonce: 5},
+	}
+	results := make([]*common.TxResult, 0, len(txs))
+	for _, tx := range txs {
+		results = append(results, block.NewTransaction(cLog, tx, block.Fee))
+	}
+	if results[0].Fee != 10 || results[2].Success || results[2].Fee != 0 {
+		fmt.Println("recorded fee", results[0].Fee, results[2].Fee)
+		t.Fail()
+	}
+	block.PayFee()
+	if block.TotalFee != 20 {
+		fmt.Println("total fee", block.TotalFee)
+		t.Fail()
+	}
+	expected := map[string]int64{from: 1000 - 100 - 20, to: 100, producer: 20}
+	for address, amount := range expected {
+		addr, _ := hex.DecodeString(address)
+		account, err := block.GetAccount(cLog, addr)
+		if err != nil || account.Amount != amount {
+			fmt.Println(address, account, err)
+			t.Fail()
+		}
+	}
+	if account, _ := block.GetAccount(cLog, []byte{1}); account.Nonce != 2 || account.Balances["token"] != 30 {
+		fmt.Println("sender", account)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestIssueToken(t *testing.T) {
+	store := db.NewMemoryDB()
+	issuer := "01"
+	block := &Block{
+		Height:    1,
+		Fee:       10,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		TokenTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{CurrentIndex: 0},
+		store:     store,
+	}
+	block.InsertAccount(common.CreateAccount(issuer, 100))
+
+	cLog := context_log.NewContextLog("test")
+	issue := &common.Transaction{From: issuer, Nonce: 1, Data: `{"name": "Gold", "total": 5000, "decimals": 8}`}
+	if txResult := block.NewTransaction(cLog, issue, block.Fee); !txResult.Success {
+		fmt.Println(txResult.FailMsg)
+		t.FailNow()
+	}
+	// 名字重复的token不能发行,交易失败时不收手续费
+	duplicate := &common.Transaction{From: issuer, Nonce: 2, Data: `{"name": "Gold", "total": 1, "decimals": 2}`}
+	if txResult := block.NewTransaction(cLog, duplicate, block.Fee); txResult.Success || txResult.FailMsg != "duplicate token name" {
+		fmt.Println("duplicate token issued", txResult)
+		t.Fail()
+	}
+	invalid := &common.Transaction{From: issuer, Nonce: 2, Data: `{"name": "Silver", "total": 0, "decimals": 2}`}
+	if txResult := block.NewTransaction(cLog, invalid, block.Fee); txResult.Success {
+		t.Fail()
+	}
+
+	token := common.Token{Name: "Gold", Total: 5000, Decimals: 8, Issuer: issuer}
+	if issued, err := block.GetToken(token.Address()); err != nil || *issued != token {
+		fmt.Println("token not found", issued, err)
+		t.Fail()
+	}
+	if issued, err := block.GetTokenByName("Gold"); err != nil || *issued != token {
+		fmt.Println("token not found by name", issued, err)
+		t.Fail()
+	}
+	if tokens, err := block.Tokens(); err != nil || len(tokens) != 1 {
+		fmt.Println(tokens, err)
+		t.Fail()
+	}
+	account, _ := block.GetAccount(cLog, []byte{1})
+	if account.Amount != 90 || account.Nonce != 1 || account.Balances[hex.EncodeToString(token.Address())] != 5000 {
+		fmt.Println("issuer", account)
+		t.Fail()
+	}
+	if block.TotalFee != 10 {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
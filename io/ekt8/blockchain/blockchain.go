@@ -5,18 +5,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"errors"
 
+	"github.com/EducationEKT/EKT/io/ekt8/conf"
 	"github.com/EducationEKT/EKT/io/ekt8/context_log"
 	"github.com/EducationEKT/EKT/io/ekt8/core/common"
 	"github.com/EducationEKT/EKT/io/ekt8/crypto"
 	"github.com/EducationEKT/EKT/io/ekt8/db"
 	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
 	"github.com/EducationEKT/EKT/io/ekt8/log"
-	"github.com/EducationEKT/EKT/io/ekt8/param"
 	"github.com/EducationEKT/EKT/io/ekt8/pool"
 )
 
@@ -37,7 +38,6 @@ const (
 )
 
 const (
-	CurrentBlockKey = "CurrentBlockKey"
 	InitStatus      = 0
 	StartPackStatus = 100
 )
@@ -58,10 +58,14 @@ type BlockChain struct {
 	Police        BlockPolice
 	BlockManager  *BlockManager
 	PackLock      sync.RWMutex
+	DB            db.KVStore `json:"-"`
+	Forks         *BlockTree `json:"-"`
+	listeners     []func(block *Block)
 }
 
-func NewBlockChain(chainId []byte, consensusType i_consensus.ConsensusType, fee int64, difficulty []byte, interval time.Duration) *BlockChain {
+func NewBlockChain(store db.KVStore, chainId []byte, consensusType i_consensus.ConsensusType, fee int64, difficulty []byte, interval time.Duration) *BlockChain {
 	return &BlockChain{
+		DB:            store,
 		ChainId:       chainId,
 		Consensus:     consensusType,
 		currentBlock:  nil,
@@ -77,6 +81,7 @@ func NewBlockChain(chainId []byte, consensusType i_consensus.ConsensusType, fee
 		Police:        NewBlockPolice(),
 		BlockManager:  NewBlockManager(),
 		PackLock:      sync.RWMutex{},
+		Forks:         NewBlockTree(),
 	}
 }
 
@@ -122,6 +127,9 @@ func (blockchain *BlockChain) PackSignal(height int64) *Block {
 		log.GetLogInst().LogInfo("Start pack block at height %d .\n", blockchain.GetLastHeight()+1)
 		log.GetLogInst().LogDebug("Start pack block at height %d .\n", blockchain.GetLastHeight()+1)
 		block := blockchain.WaitAndPack()
+		if block == nil {
+			return nil
+		}
 		log.GetLogInst().LogInfo("Packed a block at height %d, block info: %s .\n", blockchain.GetLastHeight()+1, string(block.Bytes()))
 		log.GetLogInst().LogDebug("Packed a block at height %d, block info: %s .\n", blockchain.GetLastHeight()+1, string(block.Bytes()))
 		return block
@@ -146,63 +154,103 @@ func (blockchain *BlockChain) GetBlockByHeight(height int64) (*Block, error) {
 		return nil, errors.New("Invalid height")
 	}
 	key := blockchain.GetBlockByHeightKey(height)
-	data, err := db.GetDBInst().Get(key)
+	data, err := blockchain.DB.Get(key)
 	if err != nil {
 		return nil, err
 	}
 	if len(data) == 0 {
 		return nil, errors.New("Too heigher.")
 	}
-	block, err := FromBytes2Block(data)
+	block, err := fromBytes2Block(blockchain.DB, data)
+	if err != nil {
+		return nil, err
+	}
 	if block.Height != height {
 		return nil, errors.New("Can not get block from db.")
 	}
 	return block, err
 }
 
+// 根据Hash查询主链上的区块
+func (blockchain *BlockChain) GetBlockByHash(hash []byte) (*Block, error) {
+	data, err := blockchain.DB.Get(blockchain.BlockIndexKey(hash))
+	if err != nil {
+		return nil, errors.New("Not Exist")
+	}
+	height, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := blockchain.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(block.Hash(), hash) {
+		return nil, errors.New("Not Exist")
+	}
+	return block, nil
+}
+
 func (blockchain *BlockChain) GetBlockByHeightKey(height int64) []byte {
-	return []byte(fmt.Sprint(`GetBlockByHeight: _%s_%d`, hex.EncodeToString(blockchain.ChainId), height))
+	return db.HeightNamespace.Key(blockchain.ChainId, db.HeightBytes(height))
 }
 
-func (blockchain *BlockChain) SaveBlock(block *Block) {
+// 区块和投票结果在同一个batch中写入,写入失败时不会修改当前区块
+func (blockchain *BlockChain) SaveBlock(block *Block, votes Votes) error {
 	blockchain.Locker.Lock()
 	defer blockchain.Locker.Unlock()
-	if blockchain.GetLastHeight()+1 == block.Height {
-		fmt.Println("Saving block to database.")
-		db.GetDBInst().Set(block.Hash(), block.Data())
-		data, _ := json.Marshal(block)
-		db.GetDBInst().Set(blockchain.GetBlockByHeightKey(block.Height), data)
-		db.GetDBInst().Set(blockchain.CurrentBlockKey(), data)
-		blockchain.SetLastBlock(block)
-		blockchain.SetLastHeight(block.Height)
-		fmt.Println("Save block to database succeed.")
+	return blockchain.saveBlock(block, votes)
+}
+
+func (blockchain *BlockChain) saveBlock(block *Block, votes Votes) error {
+	// 第一次启动时写入高度为0的创世块
+	if blockchain.GetLastHeight()+1 != block.Height && !(block.Height == 0 && blockchain.GetLastBlock() == nil) {
+		return errors.New("Invalid height")
+	}
+	fmt.Println("Saving block to database.")
+	batch, err := blockchain.commitBatch(block, votes)
+	if err == nil {
+		err = blockchain.DB.WriteBatch(batch)
+	}
+	if err != nil {
+		log.GetLogInst().LogCrit("Save block to database failed. %v", err)
+		return err
 	}
+	blockchain.SetLastBlock(block)
+	blockchain.SetLastHeight(block.Height)
+	fmt.Println("Save block to database succeed.")
+	for _, listener := range blockchain.listeners {
+		listener(block)
+	}
+	if retain := conf.EKTConfig.Prune.Retain; retain > 0 && block.Height%retain == 0 {
+		go blockchain.AutoPrune()
+	}
+	return nil
+}
+
+// 区块写入数据库之后调用listener,listener不能阻塞
+func (blockchain *BlockChain) Subscribe(listener func(block *Block)) {
+	blockchain.Locker.Lock()
+	defer blockchain.Locker.Unlock()
+	blockchain.listeners = append(blockchain.listeners, listener)
 }
 
 func (blockchain *BlockChain) LastBlock() (*Block, error) {
-	var err error = nil
+	data, err := blockchain.DB.Get(blockchain.CurrentBlockKey())
+	if err != nil {
+		return nil, err
+	}
 	var block *Block
-	if currentBlock == nil {
-		key := blockchain.CurrentBlockKey()
-		data, err := db.GetDBInst().Get(key)
-		if err != nil {
-			return nil, err
-		}
-		err = json.Unmarshal(data, &block)
-		if err != nil {
-			return nil, err
-		}
-		currentBlock = block
-		return block, err
+	err = json.Unmarshal(data, &block)
+	if err != nil {
+		return nil, err
 	}
-	return currentBlock, err
+	block.store = blockchain.DB
+	return block, nil
 }
 
 func (blockchain *BlockChain) CurrentBlockKey() []byte {
-	buffer := bytes.Buffer{}
-	buffer.WriteString(CurrentBlockKey)
-	buffer.Write(blockchain.ChainId)
-	return buffer.Bytes()
+	return db.HeadNamespace.Key(blockchain.ChainId)
 }
 
 func (blockchain *BlockChain) PackTime() time.Duration {
@@ -216,15 +264,16 @@ func (blockchain *BlockChain) PackTime() time.Duration {
 func (blockchain *BlockChain) WaitAndPack() *Block {
 	// 打包10500个交易大概需要0.95秒
 	eventTimeout := time.After(blockchain.PackTime())
-	round := &i_consensus.Round{
-		Peers:        param.MainChainDPosNode,
-		CurrentIndex: 0,
-	}
-	if blockchain.GetLastHeight() != 0 {
-		round = blockchain.GetLastBlock().GetRound().MyRound(blockchain.GetLastBlock().CurrentHash)
+	// 区块的时间决定区块所在的时间槽,区块的Round必须和时间槽一致
+	now := time.Now().UnixNano() / 1e6
+	slot, err := blockchain.CurrentSlot(now)
+	if err != nil {
+		log.GetLogInst().LogCrit("Read current slot failed. %v", err)
+		return nil
 	}
 	log.GetLogInst().LogDebug("")
-	block := NewBlock(blockchain.GetLastBlock(), round)
+	block := NewBlock(blockchain.GetLastBlock(), slot.Round)
+	block.Timestamp = now
 	fmt.Println("Packing transaction and other events.")
 	for {
 		flag := false
@@ -239,10 +288,8 @@ func (blockchain *BlockChain) WaitAndPack() *Block {
 				log := context_log.NewContextLog("BlockFromTxPool")
 				defer log.Finish()
 				log.Log("tx", tx)
-				log.Log("block.StatRoot_p", block.StatTree.Root)
 				txResult := block.NewTransaction(log, tx, block.Fee)
 				log.Log("txResult", txResult)
-				log.Log("block.StatRoot_a", block.StatTree.Root)
 				blockchain.Pool.Notify(tx.TransactionId())
 				block.BlockBody.AddTxResult(*txResult)
 			}
@@ -251,10 +298,18 @@ func (blockchain *BlockChain) WaitAndPack() *Block {
 			break
 		}
 	}
+	block.PayFee()
 	bodyData := block.BlockBody.Bytes()
 	block.Body = crypto.Sha3_256(bodyData)
-	db.GetDBInst().Set(block.Body, bodyData)
-	block.UpdateMPTPlusRoot()
+	// 区块体和树的节点没有写入数据库时不能签名和广播这个区块,返回nil之后PackSignal会恢复打包状态
+	if err := blockchain.DB.Set(block.Body, bodyData); err != nil {
+		log.GetLogInst().LogCrit("Write block body to database failed. %v", err)
+		return nil
+	}
+	if err := block.Commit(); err != nil {
+		log.GetLogInst().LogCrit("Write block stat to database failed. %v", err)
+		return nil
+	}
 	return block
 }
 
@@ -290,8 +345,14 @@ func (blockchain *BlockChain) BlockFromPeer(cLog *context_log.ContextLog, block
 		fmt.Println("Block timestamp is more than 2/3 block interval, abort vote.")
 		return false
 	}
-	if !blockchain.GetLastBlock().ValidateNextBlock(block, blockchain.BlockInterval) {
-		fmt.Println("This block from peer can not recover by last block, abort.")
+	// 父区块可以是分支上的区块,写入时再根据分叉选择决定是否切换分支
+	parent, err := blockchain.GetParent(&block)
+	if err != nil {
+		fmt.Println("The parent of this block from peer is unknown, abort.")
+		return false
+	}
+	if !parent.ValidateNextBlock(block, blockchain.BlockInterval) {
+		fmt.Println("This block from peer can not recover by its parent, abort.")
 		return false
 	}
 	return true
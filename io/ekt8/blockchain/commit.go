@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+// 区块的投票结果在数据库中的key
+func VotesKey(blockHash []byte) []byte {
+	return db.VotesNamespace.Key(blockHash)
+}
+
+// 交易在区块链中的位置,和db.migrateBlockIndex中写入的格式一致
+type TxLocation struct {
+	Height    int64           `json:"height"`
+	BlockHash common.HexBytes `json:"blockHash"`
+	Index     int             `json:"index"`
+}
+
+// 区块Hash对应的高度在数据库中的key
+func (blockchain *BlockChain) BlockIndexKey(blockHash []byte) []byte {
+	return db.BlockIndexNamespace.Key(blockchain.ChainId, blockHash)
+}
+
+// 交易所在区块的位置在数据库中的key
+func (blockchain *BlockChain) TxIndexKey(txId []byte) []byte {
+	return db.TxIndexNamespace.Key(blockchain.ChainId, txId)
+}
+
+// 根据交易的id查询交易所在区块的高度、Hash和在区块体中的位置
+func (blockchain *BlockChain) GetTxLocation(txId []byte) (*TxLocation, error) {
+	data, err := blockchain.DB.Get(blockchain.TxIndexKey(txId))
+	if err != nil {
+		return nil, err
+	}
+	var location TxLocation
+	if err = json.Unmarshal(data, &location); err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// 根据交易的id查询交易所在区块的高度
+func (blockchain *BlockChain) GetTxHeight(txId []byte) (int64, error) {
+	location, err := blockchain.GetTxLocation(txId)
+	if err != nil {
+		return 0, err
+	}
+	return location.Height, nil
+}
+
+/*
+*把区块写入数据库需要的所有数据放在同一个batch中写入
+*
+*包括区块Hash对应的数据、区块体、高度索引、Hash索引、投票结果、交易索引和CurrentBlockKey
+*区块的状态树在写入batch之前已经提交,CurrentBlockKey指向的区块的状态一定是完整的
+ */
+func (blockchain *BlockChain) commitBatch(block *Block, votes Votes) (db.Batch, error) {
+	batch := blockchain.DB.NewBatch()
+	if err := blockchain.writeBlock(batch, block, votes); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func (blockchain *BlockChain) writeBlock(batch db.Batch, block *Block, votes Votes) error {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	batch.Put(block.Hash(), block.Data())
+	if block.BlockBody != nil && len(block.Body) > 0 {
+		batch.Put(block.Body, block.BlockBody.Bytes())
+		for i, txResult := range block.BlockBody.TxResults {
+			txId, err := hex.DecodeString(txResult.TxId)
+			if err != nil {
+				return err
+			}
+			location, _ := json.Marshal(TxLocation{Height: block.Height, BlockHash: block.Hash(), Index: i})
+			batch.Put(blockchain.TxIndexKey(txId), location)
+		}
+	}
+	batch.Put(blockchain.BlockIndexKey(block.Hash()), []byte(strconv.FormatInt(block.Height, 10)))
+	if err := blockchain.writeMissedSlots(batch, block); err != nil {
+		return err
+	}
+	if votes.Len() > 0 {
+		batch.Put(VotesKey(block.Hash()), votes.Bytes())
+	}
+	batch.Put(blockchain.GetBlockByHeightKey(block.Height), data)
+	batch.Put(blockchain.CurrentBlockKey(), data)
+	return nil
+}
+
+/*
+*检查上次退出时是否有没有完成的区块写入,并修复CurrentBlockKey和高度索引
+*
+*旧版本分多次写入区块,中途退出时可能只写入了一部分
+*CurrentBlockKey指向的区块缺少高度索引或者Hash对应的数据时补充写入
+*高于CurrentBlockKey的高度索引是没有完成写入的区块,删除之后重新同步
+ */
+func (blockchain *BlockChain) RepairHead() (bool, error) {
+	data, err := blockchain.DB.Get(blockchain.CurrentBlockKey())
+	if err != nil || len(data) == 0 {
+		return false, nil
+	}
+	var head Block
+	if err = json.Unmarshal(data, &head); err != nil {
+		return false, err
+	}
+	if len(head.StatRoot) > 0 {
+		if exist, _ := blockchain.DB.Has(head.StatRoot); !exist {
+			return false, errors.New("State of current block is missing")
+		}
+	}
+	batch := blockchain.DB.NewBatch()
+	indexKey := blockchain.GetBlockByHeightKey(head.Height)
+	if index, err := blockchain.DB.Get(indexKey); err != nil || !bytes.Equal(index, data) {
+		batch.Put(indexKey, data)
+	}
+	if hashData, err := blockchain.DB.Get(head.CurrentHash); err != nil || !bytes.Equal(hashData, head.Data()) {
+		batch.Put(head.CurrentHash, head.Data())
+	}
+	for height := head.Height + 1; ; height++ {
+		key := blockchain.GetBlockByHeightKey(height)
+		if exist, _ := blockchain.DB.Has(key); !exist {
+			break
+		}
+		batch.Delete(key)
+	}
+	if batch.Len() == 0 {
+		return false, nil
+	}
+	return true, blockchain.DB.WriteBatch(batch)
+}
@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+)
+
+func TestCommitAndRepair(t *testing.T) {
+	store := db.NewMemoryDB()
+	chain := NewBlockChain(store, BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	block := &Block{
+		Height:    1,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{CurrentIndex: 0},
+		BlockBody: NewBlockBody(1),
+		store:     store,
+	}
+	block.StatTree.MustInsert([]byte("account"), []byte("value"))
+	txId := crypto.Sha3_256([]byte("tx"))
+	block.BlockBody.TxResults = append(block.BlockBody.TxResults, common.TxResult{TxId: hex.EncodeToString(txId)})
+	block.Body = crypto.Sha3_256(block.BlockBody.Bytes())
+	block.UpdateMPTPlusRoot()
+	block.CaculateHash()
+	if err := block.Commit(); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	votes := Votes{BlockVote{BlockHash: block.Hash(), BlockHeight: 1, VoteResult: true}}
+	if err := chain.SaveBlock(block, votes); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if location, err := chain.GetTxLocation(txId); err != nil || location.Height != 1 || location.Index != 0 || !bytes.Equal(location.BlockHash, block.Hash()) {
+		fmt.Println("tx index not found", err)
+		t.Fail()
+	}
+	if byHash, err := chain.GetBlockByHash(block.Hash()); err != nil || byHash.Height != 1 {
+		fmt.Println("block index not found", err)
+		t.Fail()
+	}
+	if data, err := store.Get(VotesKey(block.Hash())); err != nil || !bytes.Equal(data, votes.Bytes()) {
+		fmt.Println("votes not found", err)
+		t.Fail()
+	}
+	if repaired, err := chain.RepairHead(); repaired || err != nil {
+		fmt.Println("complete commit should not be repaired", err)
+		t.Fail()
+	}
+
+	// 模拟旧版本写入区块时中途退出: 下一个区块只写入了高度索引,当前区块缺少高度索引
+	store.Set(chain.GetBlockByHeightKey(2), []byte("unfinished"))
+	store.Delete(chain.GetBlockByHeightKey(1))
+	if repaired, err := chain.RepairHead(); !repaired || err != nil {
+		fmt.Println("partial commit not repaired", err)
+		t.Fail()
+	}
+	if exist, _ := store.Has(chain.GetBlockByHeightKey(2)); exist {
+		t.Fail()
+	}
+	chain.SetLastHeight(1)
+	if last, err := chain.GetBlockByHeight(1); err != nil || !bytes.Equal(last.Hash(), block.Hash()) {
+		fmt.Println("height index not repaired", err)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -0,0 +1,288 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+	"github.com/EducationEKT/EKT/io/ekt8/param"
+)
+
+const (
+	// 每一轮出块的节点数量
+	DelegateCount = 21
+	// 得票的候选节点少于这个数量时继续使用上一轮的节点
+	MinDelegateCount = 3
+)
+
+// 所有候选节点保存在StatTree的这个key下面,和账户一起计算StatRoot
+var CandidatesKey = crypto.Sha3_256([]byte("DPoS candidates"))
+
+// 治理账户安排的出块节点变更保存在StatTree的这个key下面
+var ValidatorChangesKey = crypto.Sha3_256([]byte("DPoS validator changes"))
+
+// 治理账户的地址在创世块中写入StatTree的这个key下面,所有节点都从链上的状态读取
+var GovernanceKey = crypto.Sha3_256([]byte("DPoS governance"))
+
+/*
+*保存选举状态的key和账户在同一个StatTree中,这些地址不能作为交易的接收方
+*
+*否则转账会在这些key下面写入一个账户,覆盖掉选举状态
+ */
+func IsReservedAddress(address []byte) bool {
+	return bytes.Equal(address, CandidatesKey) || bytes.Equal(address, ValidatorChangesKey) || bytes.Equal(address, GovernanceKey)
+}
+
+// 所有候选节点,按照AccountAddress排序
+func (block *Block) Candidates() ([]common.Candidate, error) {
+	candidates := make([]common.Candidate, 0)
+	if !block.ExistAddress(CandidatesKey) {
+		return candidates, nil
+	}
+	err := block.StatTree.GetInterfaceValue(CandidatesKey, &candidates)
+	return candidates, err
+}
+
+/*
+*得票最多的count个候选节点,得票相同时AccountAddress小的节点在前
+*
+*没有得到投票的候选节点不会被选中
+ */
+func (block *Block) Delegates(count int) ([]p2p.Peer, error) {
+	candidates, err := block.Candidates()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Votes > candidates[j].Votes
+	})
+	delegates := make([]p2p.Peer, 0, count)
+	for _, candidate := range candidates {
+		if len(delegates) == count || candidate.Votes <= 0 {
+			break
+		}
+		delegates = append(delegates, candidate.Peer)
+	}
+	return delegates, nil
+}
+
+// 所有已经安排的出块节点变更,按照生效的高度排序
+func (block *Block) ValidatorChanges() ([]common.ValidatorChange, error) {
+	changes := make([]common.ValidatorChange, 0)
+	if !block.ExistAddress(ValidatorChangesKey) {
+		return changes, nil
+	}
+	err := block.StatTree.GetInterfaceValue(ValidatorChangesKey, &changes)
+	return changes, err
+}
+
+// 可以安排出块节点变更的治理账户,没有设置时返回空字符串
+func (block *Block) Governance() (string, error) {
+	if !block.ExistAddress(GovernanceKey) {
+		return "", nil
+	}
+	value, err := block.StatTree.GetValue(GovernanceKey)
+	return string(value), err
+}
+
+// 在创世块中设置治理账户,address为空时不设置
+func (block *Block) SetGovernance(address string) error {
+	if address == "" {
+		return nil
+	}
+	return block.StatTree.MustInsert(GovernanceKey, []byte(address))
+}
+
+/*
+*执行选举交易: 注册候选节点、投票、撤回投票和安排出块节点变更
+*
+*投票的EKT从账户中扣除并记录在账户的Votes中,撤回时返回账户,所有选举交易都需要支付手续费
+ */
+func (block *Block) election(tx *common.Transaction, fee int64, fromAddress []byte, account *common.Account) *common.TxResult {
+	action, err := tx.ElectionAction()
+	if err != nil {
+		return common.NewTransactionResult(tx, fee, false, err.Error())
+	}
+	if action.Action == common.ScheduleChange {
+		return block.scheduleChange(tx, fee, fromAddress, account, *action.Change)
+	}
+	candidates, err := block.Candidates()
+	if err != nil {
+		return common.NewTransactionResult(tx, fee, false, "read candidates failed")
+	}
+	index := -1
+	candidate := action.Candidate
+	if action.Action == common.RegisterCandidate {
+		candidate = action.Peer.AccountAddress
+	}
+	for i, c := range candidates {
+		if c.Peer.AccountAddress == candidate {
+			index = i
+		} else if action.Action == common.RegisterCandidate && (c.Peer.PeerId == action.Peer.PeerId || c.Peer.Equal(*action.Peer)) {
+			return common.NewTransactionResult(tx, fee, false, "duplicate peer")
+		}
+	}
+	switch action.Action {
+	case common.RegisterCandidate:
+		if account.GetAmount() < fee {
+			return common.NewTransactionResult(tx, fee, false, "no enough gas")
+		}
+		account.ReduceAmount(fee)
+		if index == -1 {
+			candidates = append(candidates, common.Candidate{Peer: *action.Peer})
+			sort.Slice(candidates, func(i, j int) bool {
+				return candidates[i].Peer.AccountAddress < candidates[j].Peer.AccountAddress
+			})
+		} else {
+			// 重复注册时更新节点的信息,保留已经得到的投票
+			candidates[index].Peer = *action.Peer
+		}
+	case common.VoteCandidate:
+		if index == -1 {
+			return common.NewTransactionResult(tx, fee, false, "candidate not exist")
+		}
+		if account.GetAmount() < tx.Amount+fee {
+			return common.NewTransactionResult(tx, fee, false, "no enough gas")
+		}
+		account.ReduceAmount(tx.Amount + fee)
+		if account.Votes == nil {
+			account.Votes = make(map[string]int64)
+		}
+		account.Votes[candidate] += tx.Amount
+		candidates[index].Votes += tx.Amount
+	case common.WithdrawVote:
+		if index == -1 || account.Votes[candidate] < tx.Amount {
+			return common.NewTransactionResult(tx, fee, false, "no enough votes")
+		}
+		if account.GetAmount()+tx.Amount < fee {
+			return common.NewTransactionResult(tx, fee, false, "no enough gas")
+		}
+		account.ReduceAmount(fee)
+		account.AddAmount(tx.Amount)
+		if account.Votes[candidate] -= tx.Amount; account.Votes[candidate] == 0 {
+			delete(account.Votes, candidate)
+		}
+		candidates[index].Votes -= tx.Amount
+	}
+	value, _ := json.Marshal(candidates)
+	snapshot := block.StatTree.Snapshot()
+	if block.StatTree.MustInsert(CandidatesKey, value) != nil || block.StatTree.MustInsert(fromAddress, account.ToBytes()) != nil {
+		block.StatTree.RevertToSnapshot(snapshot)
+		return common.NewTransactionResult(tx, fee, false, "update account failed")
+	}
+	return common.NewTransactionResult(tx, fee, true, "")
+}
+
+/*
+*安排出块节点的变更,只有创世块中设置的治理账户可以发送
+*
+*变更的高度必须大于当前区块的高度,在这个高度之后的第一个轮次开始时生效,见RoundAfter
+ */
+func (block *Block) scheduleChange(tx *common.Transaction, fee int64, fromAddress []byte, account *common.Account, change common.ValidatorChange) *common.TxResult {
+	governance, err := block.Governance()
+	if err != nil {
+		return common.NewTransactionResult(tx, fee, false, "read governance failed")
+	}
+	if governance == "" || tx.From != governance {
+		return common.NewTransactionResult(tx, fee, false, "permission denied")
+	}
+	if change.Height <= block.Height {
+		return common.NewTransactionResult(tx, fee, false, "invalid height")
+	}
+	changes, err := block.ValidatorChanges()
+	if err != nil {
+		return common.NewTransactionResult(tx, fee, false, "read validator changes failed")
+	}
+	if account.GetAmount() < fee {
+		return common.NewTransactionResult(tx, fee, false, "no enough gas")
+	}
+	account.ReduceAmount(fee)
+	changes = append(changes, change)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Height < changes[j].Height
+	})
+	value, _ := json.Marshal(changes)
+	snapshot := block.StatTree.Snapshot()
+	if block.StatTree.MustInsert(ValidatorChangesKey, value) != nil || block.StatTree.MustInsert(fromAddress, account.ToBytes()) != nil {
+		block.StatTree.RevertToSnapshot(snapshot)
+		return common.NewTransactionResult(tx, fee, false, "update account failed")
+	}
+	return common.NewTransactionResult(tx, fee, true, "")
+}
+
+// 下一个区块使用的轮次
+func (blockchain *BlockChain) CurrentRound() (*i_consensus.Round, error) {
+	last := blockchain.GetLastBlock()
+	if blockchain.GetLastHeight() == 0 || last == nil {
+		return &i_consensus.Round{Peers: param.MainChainDPosNode, CurrentIndex: -1}, nil
+	}
+	return RoundAfter(last)
+}
+
+/*
+*block的下一个区块使用的轮次
+*
+*一轮结束时用block的状态选出下一轮的节点,见NextPeers
+*返回的轮次CurrentIndex仍然是最后一个位置,下一个区块的节点顺序见ScheduleAfter
+*读取选举状态失败时返回错误,不能用错误的节点继续出块和校验
+ */
+func RoundAfter(block *Block) (*i_consensus.Round, error) {
+	if block.Height == 0 || block.Round == nil {
+		return &i_consensus.Round{Peers: param.MainChainDPosNode, CurrentIndex: -1}, nil
+	}
+	round := block.GetRound()
+	if round.CurrentIndex != round.Len()-1 {
+		return round, nil
+	}
+	peers, err := block.NextPeers(round.Peers)
+	if err != nil {
+		return nil, err
+	}
+	round.Peers = peers
+	round.CurrentIndex = round.Len() - 1
+	return round, nil
+}
+
+/*
+*peers所在的轮次结束之后,下一轮的节点
+*
+*得票的候选节点不够时继续使用peers,然后按照高度依次应用所有高度不超过block的变更,
+*变更可以重复应用,所以每一轮都从头应用也会得到相同的结果
+ */
+func (block *Block) NextPeers(peers []p2p.Peer) ([]p2p.Peer, error) {
+	next := peers
+	delegates, err := block.Delegates(DelegateCount)
+	if err != nil {
+		return nil, err
+	}
+	if len(delegates) >= MinDelegateCount {
+		next = delegates
+	}
+	changes, err := block.ValidatorChanges()
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if change.Height > block.Height {
+			break
+		}
+		next = change.Apply(next)
+	}
+	if len(next) == 0 {
+		return peers, nil
+	}
+	return next, nil
+}
+
+// 产生block的轮次,校验block的投票时使用block的高度上生效的节点
+func (blockchain *BlockChain) RoundOf(block *Block) (*i_consensus.Round, error) {
+	parent, err := blockchain.GetParent(block)
+	if err != nil {
+		return nil, err
+	}
+	return RoundAfter(parent)
+}
@@ -0,0 +1,249 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/context_log"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+func TestElection(t *testing.T) {
+	store := db.NewMemoryDB()
+	bootNode := p2p.Peer{PeerId: "boot", Address: "127.0.0.1", Port: 19950}
+	block := &Block{
+		Height:    1,
+		Fee:       1,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{Peers: []p2p.Peer{bootNode}, CurrentIndex: 0},
+		store:     store,
+	}
+	voter := "0a"
+	block.InsertAccount(common.CreateAccount(voter, 1000))
+	cLog := context_log.NewContextLog("test")
+	nonces := make(map[string]int64)
+	send := func(from string, amount int64, data string) *common.TxResult {
+		nonces[from]++
+		tx := &common.Transaction{From: from, To: common.ElectionAddress, Amount: amount, Nonce: nonces[from], Data: data}
+		return block.NewTransaction(cLog, tx, block.Fee)
+	}
+
+	candidates := []string{"01", "02", "03", "04"}
+	for i, address := range candidates {
+		block.InsertAccount(common.CreateAccount(address, 10))
+		peer := fmt.Sprintf(`{"action": "register", "peer": {"peerId": "%s", "address": "127.0.0.1", "port": %d, "accountAddress": "%s"}}`, address, 19951+i, address)
+		if txResult := send(address, 0, peer); !txResult.Success {
+			fmt.Println(txResult.FailMsg)
+			t.FailNow()
+		}
+	}
+	// 同一个节点不能被不同的账户注册
+	block.InsertAccount(common.CreateAccount("05", 10))
+	if txResult := send("05", 0, `{"action": "register", "peer": {"peerId": "01", "address": "127.0.0.2", "port": 1, "accountAddress": "05"}}`); txResult.Success {
+		t.Fail()
+	}
+
+	for address, amount := range map[string]int64{"01": 100, "02": 300, "03": 200} {
+		if txResult := send(voter, amount, `{"action": "vote", "candidate": "`+address+`"}`); !txResult.Success {
+			fmt.Println(txResult.FailMsg)
+			t.FailNow()
+		}
+	}
+	if txResult := send(voter, 150, `{"action": "withdraw", "candidate": "02"}`); !txResult.Success {
+		fmt.Println(txResult.FailMsg)
+		t.FailNow()
+	}
+	if txResult := send(voter, 1000, `{"action": "withdraw", "candidate": "02"}`); txResult.Success {
+		fmt.Println("withdraw more than voted")
+		t.Fail()
+	}
+	account, _ := block.GetAccount(cLog, []byte{0x0a})
+	if account.Amount != 1000-600+150-4 || account.Votes["02"] != 150 {
+		fmt.Println("voter", account)
+		t.Fail()
+	}
+
+	// 转账到保存候选节点的key会覆盖掉候选节点列表,这样的交易必须失败
+	nonces[voter]++
+	reserved := &common.Transaction{From: voter, To: hex.EncodeToString(CandidatesKey), Amount: 1, Nonce: nonces[voter]}
+	if txResult := block.NewTransaction(cLog, reserved, block.Fee); txResult.Success || txResult.FailMsg != "reserved address" {
+		fmt.Println("transfer to reserved address", txResult)
+		t.Fail()
+	}
+	nonces[voter]--
+	if candidates, err := block.Candidates(); err != nil || len(candidates) != 4 {
+		fmt.Println("candidates overwritten", candidates, err)
+		t.Fail()
+	}
+
+	// 03得票200,02得票150,01得票100,04没有得票
+	delegates, err := block.Delegates(DelegateCount)
+	if err != nil || len(delegates) != 3 || delegates[0].PeerId != "03" || delegates[1].PeerId != "02" || delegates[2].PeerId != "01" {
+		fmt.Println("delegates", delegates)
+		t.Fail()
+	}
+	if delegates, _ = block.Delegates(2); len(delegates) != 2 {
+		t.Fail()
+	}
+
+	// 一轮结束之后使用选举产生的节点
+	block.CaculateHash()
+	chain := NewBlockChain(store, BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	chain.SetLastBlock(block)
+	chain.SetLastHeight(block.Height)
+	if round, err := chain.CurrentRound(); err != nil || round.Len() != 3 || round.CurrentIndex != 2 {
+		fmt.Println("round", round)
+		t.Fail()
+	}
+	block.Round = &i_consensus.Round{Peers: []p2p.Peer{bootNode, bootNode}, CurrentIndex: 0}
+	if round, err := chain.CurrentRound(); err != nil || round.Len() != 2 {
+		fmt.Println("delegates changed in the middle of a round", round)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestValidatorChange(t *testing.T) {
+	store := db.NewMemoryDB()
+	peers := make([]p2p.Peer, 0)
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		peers = append(peers, p2p.Peer{PeerId: id, Address: "127.0.0.1", Port: int32(19951 + i)})
+	}
+	block := &Block{
+		Height:    10,
+		Fee:       1,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{Peers: peers[:3], CurrentIndex: 2},
+		store:     store,
+	}
+	// 治理账户是创世块中写入的链上状态,和本地的配置无关
+	governance := "0c"
+	block.SetGovernance(governance)
+	block.InsertAccount(common.CreateAccount(governance, 10))
+	block.InsertAccount(common.CreateAccount("0d", 10))
+	cLog := context_log.NewContextLog("test")
+	nonces := make(map[string]int64)
+	schedule := func(from string, change string) *common.TxResult {
+		nonces[from]++
+		tx := &common.Transaction{From: from, To: common.ElectionAddress, Nonce: nonces[from], Data: `{"action": "schedule", "change": ` + change + `}`}
+		txResult := block.NewTransaction(cLog, tx, block.Fee)
+		// 失败的交易不修改账户的nonce
+		if !txResult.Success {
+			nonces[from]--
+		}
+		return txResult
+	}
+	replace := fmt.Sprintf(`{"height": 12, "type": "replace", "peer": %s, "replaced": %s}`, peers[3].String(), peers[1].String())
+	add := fmt.Sprintf(`{"height": 11, "type": "add", "peer": %s}`, peers[4].String())
+	if txResult := schedule("0d", add); txResult.Success {
+		fmt.Println("schedule without permission")
+		t.Fail()
+	}
+	if txResult := schedule(governance, `{"height": 10, "type": "remove", "peer": `+peers[0].String()+`}`); txResult.Success {
+		fmt.Println("schedule at past height")
+		t.Fail()
+	}
+	for _, change := range []string{replace, add} {
+		if txResult := schedule(governance, change); !txResult.Success {
+			fmt.Println(txResult.FailMsg)
+			t.FailNow()
+		}
+	}
+	// 转账到保存变更和治理账户的key会覆盖掉选举状态,这样的交易必须失败
+	for _, key := range [][]byte{ValidatorChangesKey, GovernanceKey} {
+		tx := &common.Transaction{From: "0d", To: hex.EncodeToString(key), Amount: 1, Nonce: nonces["0d"] + 1}
+		if txResult := block.NewTransaction(cLog, tx, block.Fee); txResult.Success {
+			fmt.Println("transfer to reserved address", hex.EncodeToString(key))
+			t.Fail()
+		}
+	}
+	if changes, err := block.ValidatorChanges(); err != nil || len(changes) != 2 {
+		fmt.Println("validator changes overwritten", changes, err)
+		t.Fail()
+	}
+
+	ids := func(round *i_consensus.Round) string {
+		result := ""
+		for _, peer := range round.Peers {
+			result += peer.PeerId
+		}
+		return result
+	}
+	// 变更在指定高度之后的第一个轮次开始时生效,所有节点按照高度顺序应用变更
+	for _, expect := range []struct {
+		height int64
+		peers  string
+	}{{10, "abc"}, {11, "abce"}, {12, "adce"}, {20, "adce"}} {
+		block.Height = expect.height
+		if round, err := RoundAfter(block); err != nil || ids(round) != expect.peers || round.CurrentIndex != round.Len()-1 {
+			fmt.Println("round after height", expect.height, round)
+			t.Fail()
+		}
+	}
+	// 已经应用过变更的轮次再次应用得到相同的结果
+	block.Round, _ = RoundAfter(block)
+	if round, _ := RoundAfter(block); ids(round) != "adce" {
+		fmt.Println("changes are not idempotent", round)
+		t.Fail()
+	}
+	// 一轮没有结束时不修改节点
+	block.Round = &i_consensus.Round{Peers: peers[:3], CurrentIndex: 0}
+	if round, _ := RoundAfter(block); ids(round) != "abc" {
+		fmt.Println("validators changed in the middle of a round", round)
+		t.Fail()
+	}
+
+	// 投票使用区块所在高度的节点校验
+	block.Round = &i_consensus.Round{Peers: peers[:3], CurrentIndex: 2}
+	block.Height = 12
+	block.CaculateHash()
+	chain := NewBlockChain(store, BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	chain.SetLastBlock(block)
+	chain.SetLastHeight(block.Height)
+	next := &Block{Height: 13, PreviousHash: block.Hash()}
+	round, err := chain.RoundOf(next)
+	if err != nil || ids(round) != "adce" {
+		fmt.Println("round of next block", round, err)
+		t.FailNow()
+	}
+	vote := func(peers ...p2p.Peer) Votes {
+		votes := make(Votes, 0)
+		for _, peer := range peers {
+			votes = append(votes, BlockVote{BlockHash: next.Hash(), BlockHeight: next.Height, VoteResult: true, Peer: peer})
+		}
+		return votes
+	}
+	if !vote(peers[0], peers[2], peers[3]).ValidateVoters(round) {
+		t.Fail()
+	}
+	// 被替换的节点的投票不计数
+	if vote(peers[0], peers[1], peers[2]).ValidateVoters(round) || !vote(peers[0], peers[1], peers[2]).ValidateVoters(block.Round) {
+		t.Fail()
+	}
+	// PeerId和round中的节点不一致
+	fake := peers[3]
+	fake.PeerId = "f"
+	if vote(peers[0], peers[2], fake).ValidateVoters(round) {
+		t.Fail()
+	}
+
+	// 读取变更失败时不能继续使用原来的节点
+	block.StatTree.MustInsert(ValidatorChangesKey, []byte("invalid"))
+	if round, err := RoundAfter(block); err == nil {
+		fmt.Println("invalid validator changes ignored", round)
+		t.Fail()
+	}
+	if _, err := chain.RoundOf(next); err == nil {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -0,0 +1,304 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/EducationEKT/EKT/io/ekt8/context_log"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+)
+
+// 最多回滚多少个区块,更早的分支会被丢弃
+const MaxReorgDepth = 100
+
+var UnknownParent = errors.New("Unknown parent block")
+
+type forkBlock struct {
+	block *Block
+	votes Votes
+}
+
+// 已经通过投票但是不在主链上的区块,key是区块的hash
+type BlockTree struct {
+	locker sync.RWMutex
+	blocks map[string]*forkBlock
+}
+
+func NewBlockTree() *BlockTree {
+	return &BlockTree{blocks: make(map[string]*forkBlock)}
+}
+
+func (tree *BlockTree) Insert(block *Block, votes Votes) {
+	tree.locker.Lock()
+	defer tree.locker.Unlock()
+	tree.blocks[hex.EncodeToString(block.Hash())] = &forkBlock{block: block, votes: votes}
+}
+
+func (tree *BlockTree) Get(hash []byte) (*Block, Votes) {
+	tree.locker.RLock()
+	defer tree.locker.RUnlock()
+	fork, exist := tree.blocks[hex.EncodeToString(hash)]
+	if !exist {
+		return nil, nil
+	}
+	return fork.block, fork.votes
+}
+
+func (tree *BlockTree) Remove(hash []byte) {
+	tree.locker.Lock()
+	defer tree.locker.Unlock()
+	delete(tree.blocks, hex.EncodeToString(hash))
+}
+
+func (tree *BlockTree) Blocks() []*Block {
+	tree.locker.RLock()
+	defer tree.locker.RUnlock()
+	blocks := make([]*Block, 0, len(tree.blocks))
+	for _, fork := range tree.blocks {
+		blocks = append(blocks, fork.block)
+	}
+	return blocks
+}
+
+// 删除高度不超过height的区块
+func (tree *BlockTree) RemoveBelow(height int64) {
+	tree.locker.Lock()
+	defer tree.locker.Unlock()
+	for hash, fork := range tree.blocks {
+		if fork.block.Height <= height {
+			delete(tree.blocks, hash)
+		}
+	}
+}
+
+/*
+*写入一个已经通过投票的区块
+*
+*区块的父区块是当前区块时直接写入主链,否则作为分支保存,父区块必须在主链或者分支上
+*分支的最后一个区块比当前区块更好时切换到这个分支,见preferred
+ */
+func (blockchain *BlockChain) AddBlock(block *Block, votes Votes) error {
+	blockchain.Locker.Lock()
+	defer blockchain.Locker.Unlock()
+	last := blockchain.GetLastBlock()
+	if last == nil || bytes.Equal(block.PreviousHash, last.Hash()) {
+		return blockchain.saveBlock(block, votes)
+	}
+	if blockchain.onMainChain(block.Height, block.Hash()) {
+		return nil
+	}
+	if _, err := blockchain.GetParent(block); err != nil {
+		return err
+	}
+	blockchain.Forks.Insert(block, votes)
+	blockchain.Forks.RemoveBelow(last.Height - MaxReorgDepth)
+	if !blockchain.preferred(block, votes, last) {
+		fmt.Printf("Block %s at height %d is saved as a fork. \n", hex.EncodeToString(block.Hash()), block.Height)
+		return nil
+	}
+	return blockchain.reorg(block)
+}
+
+// 父区块可以在主链上,也可以在分支上
+func (blockchain *BlockChain) GetParent(block *Block) (*Block, error) {
+	if last := blockchain.GetLastBlock(); last != nil && bytes.Equal(last.Hash(), block.PreviousHash) && last.Height+1 == block.Height {
+		return last, nil
+	}
+	if parent, _ := blockchain.Forks.Get(block.PreviousHash); parent != nil && parent.Height+1 == block.Height {
+		return parent, nil
+	}
+	if block.Height <= 0 || block.Height-1 > blockchain.GetLastHeight() {
+		return nil, UnknownParent
+	}
+	parent, err := blockchain.GetBlockByHeight(block.Height - 1)
+	if err != nil || !bytes.Equal(parent.Hash(), block.PreviousHash) {
+		return nil, UnknownParent
+	}
+	return parent, nil
+}
+
+func (blockchain *BlockChain) onMainChain(height int64, hash []byte) bool {
+	if height > blockchain.GetLastHeight() {
+		return false
+	}
+	block, err := blockchain.GetBlockByHeight(height)
+	return err == nil && bytes.Equal(block.Hash(), hash)
+}
+
+/*
+*分叉选择: 高度更高的分支更好,高度相同时投票的节点更多的区块更好,投票的节点数量也相同时hash更小的区块更好
+*
+*投票的节点和ValidateVoters一样按照区块所在轮次中的不同节点计数,重复的投票不能让区块更好
+*所有节点对相同的区块得到相同的结果,最终会选择同一个分支
+ */
+func (blockchain *BlockChain) preferred(block *Block, votes Votes, last *Block) bool {
+	if block.Height != last.Height {
+		return block.Height > last.Height
+	}
+	voters, lastVoters := blockchain.countVoters(block, votes), blockchain.countVoters(last, blockchain.getVotes(last.Hash()))
+	if voters != lastVoters {
+		return voters > lastVoters
+	}
+	return bytes.Compare(block.Hash(), last.Hash()) < 0
+}
+
+func (blockchain *BlockChain) countVoters(block *Block, votes Votes) int {
+	round, err := blockchain.RoundOf(block)
+	if err != nil {
+		return 0
+	}
+	return votes.CountVoters(round)
+}
+
+func (blockchain *BlockChain) getVotes(hash []byte) Votes {
+	data, err := blockchain.DB.Get(VotesKey(hash))
+	if err != nil {
+		return nil
+	}
+	votes := make(Votes, 0)
+	if err = json.Unmarshal(data, &votes); err != nil {
+		return nil
+	}
+	return votes
+}
+
+/*
+*切换到tip所在的分支
+*
+*分支上的区块的状态在校验时已经写入数据库,切换时只需要在一个batch中修改高度索引、Hash索引、交易索引和CurrentBlockKey,
+*相当于把状态回滚到共同祖先的root之后再执行分支上的区块
+*主链上被替换的区块保存到分支中,其中不在新分支上的交易重新放回交易池
+ */
+func (blockchain *BlockChain) reorg(tip *Block) error {
+	last := blockchain.GetLastBlock()
+	branch := make([]*forkBlock, 0)
+	for hash := tip.Hash(); ; {
+		block, votes := blockchain.Forks.Get(hash)
+		if block == nil {
+			break
+		}
+		branch = append([]*forkBlock{{block: block, votes: votes}}, branch...)
+		hash = block.PreviousHash
+	}
+	ancestor, err := blockchain.GetParent(branch[0].block)
+	if err != nil {
+		return err
+	}
+	if last.Height-ancestor.Height > MaxReorgDepth {
+		return errors.New("Reorg is too deep")
+	}
+	for _, root := range ancestor.roots() {
+		if exist, _ := blockchain.DB.Has(root); len(root) > 0 && !exist {
+			return errors.New("State of common ancestor is pruned")
+		}
+	}
+	fmt.Printf("Reorg from %s at height %d to %s at height %d, common ancestor is at height %d. \n",
+		hex.EncodeToString(last.Hash()), last.Height, hex.EncodeToString(tip.Hash()), tip.Height, ancestor.Height)
+
+	orphans := make([]*forkBlock, 0, last.Height-ancestor.Height)
+	for height := ancestor.Height + 1; height <= last.Height; height++ {
+		block, err := blockchain.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		if block.BlockBody, err = blockchain.GetBlockBody(block); err != nil {
+			return err
+		}
+		orphans = append(orphans, &forkBlock{block: block, votes: blockchain.getVotes(block.Hash())})
+	}
+	batch := blockchain.DB.NewBatch()
+	for _, orphan := range orphans {
+		batch.Delete(blockchain.GetBlockByHeightKey(orphan.block.Height))
+		batch.Delete(blockchain.BlockIndexKey(orphan.block.Hash()))
+		batch.Delete(blockchain.MissedSlotsKey(orphan.block.Height))
+		for _, txResult := range orphan.block.BlockBody.TxResults {
+			if txId, err := hex.DecodeString(txResult.TxId); err == nil {
+				batch.Delete(blockchain.TxIndexKey(txId))
+			}
+		}
+	}
+	included := make(map[string]bool)
+	for _, fork := range branch {
+		if fork.block.BlockBody, err = blockchain.GetBlockBody(fork.block); err != nil {
+			return err
+		}
+		for _, txResult := range fork.block.BlockBody.TxResults {
+			included[txResult.TxId] = true
+		}
+		if err = blockchain.writeBlock(batch, fork.block, fork.votes); err != nil {
+			return err
+		}
+	}
+	if err = blockchain.DB.WriteBatch(batch); err != nil {
+		return err
+	}
+
+	for _, fork := range branch {
+		blockchain.Forks.Remove(fork.block.Hash())
+	}
+	for _, orphan := range orphans {
+		blockchain.Forks.Insert(orphan.block, orphan.votes)
+	}
+	blockchain.SetLastBlock(tip)
+	blockchain.SetLastHeight(tip.Height)
+	for _, fork := range branch {
+		blockchain.NotifyPool(fork.block)
+		for _, listener := range blockchain.listeners {
+			listener(fork.block)
+		}
+	}
+	blockchain.reinject(orphans, included)
+	return nil
+}
+
+// 把被替换的区块中没有被新分支打包的交易重新放回交易池
+func (blockchain *BlockChain) reinject(orphans []*forkBlock, included map[string]bool) {
+	txs := make([]*common.Transaction, 0)
+	for _, orphan := range orphans {
+		for _, txResult := range orphan.block.BlockBody.TxResults {
+			if included[txResult.TxId] {
+				continue
+			}
+			txId, err := hex.DecodeString(txResult.TxId)
+			if err != nil {
+				continue
+			}
+			data, err := blockchain.DB.Get(txId)
+			if err != nil {
+				continue
+			}
+			if tx := common.FromBytes(data); tx != nil {
+				txs = append(txs, tx)
+			}
+		}
+	}
+	if len(txs) == 0 {
+		return
+	}
+	cLog, count := context_log.NewContextLog("Reinject orphaned transactions"), 0
+	for _, tx := range txs {
+		if blockchain.NewTransaction(cLog, tx) {
+			count++
+		}
+	}
+	fmt.Printf("Reinjected %d of %d transactions from orphaned blocks. \n", count, len(txs))
+}
+
+// 区块的区块体,没有加载时从数据库中读取
+func (blockchain *BlockChain) GetBlockBody(block *Block) (*BlockBody, error) {
+	if block.BlockBody != nil {
+		return block.BlockBody, nil
+	}
+	if len(block.Body) == 0 {
+		return NewBlockBody(block.Height), nil
+	}
+	data, err := blockchain.DB.Get(block.Body)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data)
+}
@@ -0,0 +1,154 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+var forkTestPeers = []p2p.Peer{
+	{PeerId: "peer0", Address: "127.0.0.1", Port: 19951},
+	{PeerId: "peer1", Address: "127.0.0.1", Port: 19952},
+	{PeerId: "peer2", Address: "127.0.0.1", Port: 19953},
+}
+
+// 测试中发送交易的账户,每个区块中的nonce都是0
+var forkTestSenders = []string{hex.EncodeToString(crypto.Sha3_256([]byte("sender0"))), hex.EncodeToString(crypto.Sha3_256([]byte("sender1")))}
+
+func newForkTestBlock(store db.KVStore, parent *Block, name string, txs ...*common.Transaction) *Block {
+	block := &Block{
+		Height:    1,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{Peers: forkTestPeers, CurrentIndex: 0},
+		BlockBody: NewBlockBody(1),
+		store:     store,
+	}
+	if parent != nil {
+		block.Height = parent.Height + 1
+		block.PreviousHash = parent.Hash()
+		block.BlockBody.Height = block.Height
+	}
+	block.StatTree.MustInsert([]byte("account"), []byte(name))
+	for _, sender := range forkTestSenders {
+		address, _ := hex.DecodeString(sender)
+		account := common.CreateAccount(sender, 100)
+		block.StatTree.MustInsert(address, account.ToBytes())
+	}
+	block.BlockBody.TxResults = append(block.BlockBody.TxResults, common.TxResult{TxId: hex.EncodeToString(crypto.Sha3_256([]byte(name)))})
+	for _, tx := range txs {
+		txId, _ := hex.DecodeString(tx.TransactionId())
+		store.Set(txId, tx.Bytes())
+		block.BlockBody.TxResults = append(block.BlockBody.TxResults, common.TxResult{TxId: tx.TransactionId(), Success: true})
+	}
+	block.Body = crypto.Sha3_256(block.BlockBody.Bytes())
+	block.UpdateMPTPlusRoot()
+	block.CaculateHash()
+	block.Commit()
+	return block
+}
+
+// 依次由peers中的节点投票
+func forkTestVotes(block *Block, peers ...p2p.Peer) Votes {
+	votes := make(Votes, 0, len(peers))
+	for i, peer := range peers {
+		votes = append(votes, BlockVote{BlockHash: block.Hash(), BlockHeight: block.Height, VoteResult: true, Peer: peer, Signature: []byte{byte(i)}})
+	}
+	return votes
+}
+
+func TestForkAndReorg(t *testing.T) {
+	store := db.NewMemoryDB()
+	chain := NewBlockChain(store, BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	// orphanTx只在2a中,sharedTx在两个分支中都被打包了
+	orphanTx := &common.Transaction{From: forkTestSenders[0], To: forkTestSenders[1], Amount: 1, Nonce: 1}
+	sharedTx := &common.Transaction{From: forkTestSenders[1], To: forkTestSenders[0], Amount: 1, Nonce: 1}
+	block1 := newForkTestBlock(store, nil, "1")
+	block2a := newForkTestBlock(store, block1, "2a", orphanTx, sharedTx)
+	block2b := newForkTestBlock(store, block1, "2b", sharedTx)
+	block3b := newForkTestBlock(store, block2b, "3b")
+	if err := chain.AddBlock(block1, forkTestVotes(block1, forkTestPeers...)); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if err := chain.AddBlock(block2a, forkTestVotes(block2a, forkTestPeers[0], forkTestPeers[1])); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+
+	// 相同高度投票的节点较少的区块只作为分支保存,同一个节点的重复投票和轮次之外的节点的投票不计数
+	outsider := p2p.Peer{PeerId: "outsider", Address: "127.0.0.2", Port: 19951}
+	padded := forkTestVotes(block2b, forkTestPeers[0], forkTestPeers[0], forkTestPeers[0], forkTestPeers[0], outsider)
+	if err := chain.AddBlock(block2b, padded); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(chain.GetLastBlock().Hash(), block2a.Hash()) {
+		fmt.Println("switched to a worse branch")
+		t.Fail()
+	}
+	if fork, _ := chain.Forks.Get(block2b.Hash()); fork == nil {
+		t.Fail()
+	}
+
+	// 父区块不在本地的区块
+	orphan := newForkTestBlock(store, block3b, "4b")
+	orphan.PreviousHash = crypto.Sha3_256([]byte("unknown"))
+	if err := chain.AddBlock(orphan, forkTestVotes(orphan, forkTestPeers...)); err != UnknownParent {
+		fmt.Println("block with unknown parent accepted", err)
+		t.Fail()
+	}
+
+	// 分支更高时切换到分支
+	if err := chain.AddBlock(block3b, forkTestVotes(block3b, forkTestPeers...)); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if chain.GetLastHeight() != 3 || !bytes.Equal(chain.GetLastBlock().Hash(), block3b.Hash()) {
+		fmt.Println("reorg failed")
+		t.FailNow()
+	}
+	if block, err := chain.GetBlockByHeight(2); err != nil || !bytes.Equal(block.Hash(), block2b.Hash()) {
+		fmt.Println("height index not switched", err)
+		t.Fail()
+	}
+	if head, err := chain.LastBlock(); err != nil || !bytes.Equal(head.Hash(), block3b.Hash()) {
+		fmt.Println("current block not switched", err)
+		t.Fail()
+	}
+	if _, err := chain.GetTxHeight(crypto.Sha3_256([]byte("2a"))); err == nil {
+		fmt.Println("tx index of orphaned block not removed")
+		t.Fail()
+	}
+	if height, err := chain.GetTxHeight(crypto.Sha3_256([]byte("2b"))); err != nil || height != 2 {
+		fmt.Println("tx index of new branch not written", err)
+		t.Fail()
+	}
+	if fork, _ := chain.Forks.Get(block2a.Hash()); fork == nil {
+		fmt.Println("orphaned block not kept as a fork")
+		t.Fail()
+	}
+	if fork, _ := chain.Forks.Get(block2b.Hash()); fork != nil {
+		t.Fail()
+	}
+
+	// 只在被替换的区块中的交易重新放回交易池
+	if tx := chain.Pool.FetchTx(); tx == nil || tx.TransactionId() != orphanTx.TransactionId() {
+		fmt.Println("orphaned transaction not reinjected", tx)
+		t.Fail()
+	}
+	if tx := chain.Pool.FetchTx(); tx != nil {
+		fmt.Println("transaction included by the new branch reinjected", tx)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
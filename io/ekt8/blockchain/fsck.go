@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/conf"
+)
+
+// Key是出错的数据在数据库中的key,Height为-1表示和具体的区块无关
+type FsckError struct {
+	Height int64  `json:"height"`
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+func (err FsckError) Error() string {
+	return fmt.Sprintf("height=%d key=%s: %s", err.Height, err.Key, err.Reason)
+}
+
+/*
+*检查数据库中的区块和状态树是否完整,每发现一个错误调用一次report,返回发现的错误数量
+*
+*检查每个高度的区块、区块Hash对应的数据和CurrentBlockKey是否一致,区块是否连续
+*开启了删除历史状态时,只检查没有被删除的区块的状态树
+ */
+func (blockchain *BlockChain) Fsck(report func(FsckError)) int {
+	count := 0
+	fail := func(height int64, key []byte, reason string, args ...interface{}) {
+		count++
+		report(FsckError{Height: height, Key: printableKey(key), Reason: fmt.Sprintf(reason, args...)})
+	}
+	currentKey := blockchain.CurrentBlockKey()
+	data, err := blockchain.DB.Get(currentKey)
+	if err != nil || len(data) == 0 {
+		fail(-1, currentKey, "current block not found")
+		return count
+	}
+	var current Block
+	if err = json.Unmarshal(data, &current); err != nil {
+		fail(-1, currentKey, "decode current block failed, %v", err)
+		return count
+	}
+
+	checked := make(map[string]bool)
+	var previous *Block
+	// 从快照启动的节点没有快照之前的区块
+	for height := blockchain.FirstHeight(); height <= current.Height; height++ {
+		key := blockchain.GetBlockByHeightKey(height)
+		data, err := blockchain.DB.Get(key)
+		if err != nil || len(data) == 0 {
+			fail(height, key, "block not found")
+			previous = nil
+			continue
+		}
+		var block Block
+		if err = json.Unmarshal(data, &block); err != nil {
+			fail(height, key, "decode block failed, %v", err)
+			previous = nil
+			continue
+		}
+		if block.Height != height {
+			fail(height, key, "unexpected height %d", block.Height)
+		}
+		if !bytes.Equal(block.CaculateHash(), block.CurrentHash) {
+			fail(height, key, "block hash mismatch")
+		}
+		if hashData, err := blockchain.DB.Get(block.CurrentHash); err != nil || !bytes.Equal(hashData, block.Data()) {
+			fail(height, block.CurrentHash, "block hash entry is different from height entry")
+		}
+		if previous != nil && !bytes.Equal(block.PreviousHash, previous.CurrentHash) {
+			fail(height, key, "previous hash %s is different from block %d", hex.EncodeToString(block.PreviousHash), height-1)
+		}
+		if height == current.Height && !bytes.Equal(block.CurrentHash, current.CurrentHash) {
+			fail(height, currentKey, "current block is different from height entry")
+		}
+		if blockchain.stateRetained(height, current.Height) {
+			for _, root := range block.roots() {
+				if len(root) == 0 {
+					continue
+				}
+				for _, trieErr := range MPTPlus.CheckTrie(blockchain.DB, root, checked) {
+					count++
+					report(FsckError{Height: height, Key: trieErr.Hash, Reason: fmt.Sprintf("trie %s, path=%s", trieErr.Reason, trieErr.Path)})
+				}
+			}
+		}
+		previous = &block
+	}
+	return count
+}
+
+// 开启删除历史状态时,只有最近的区块和checkpoints中的区块的状态会被保留
+func (blockchain *BlockChain) stateRetained(height, last int64) bool {
+	retain := conf.EKTConfig.Prune.Retain
+	if retain <= 0 || height > last-retain {
+		return true
+	}
+	for _, checkpoint := range conf.EKTConfig.Prune.Checkpoints {
+		if checkpoint == height {
+			return true
+		}
+	}
+	return false
+}
+
+// 可以打印的key直接输出,其他的key输出hex
+func printableKey(key []byte) string {
+	for _, b := range key {
+		if b < 0x20 || b > 0x7e {
+			return hex.EncodeToString(key)
+		}
+	}
+	return string(key)
+}
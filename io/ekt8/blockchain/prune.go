@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/conf"
+	"github.com/EducationEKT/EKT/io/ekt8/log"
+)
+
+// 是否有正在进行的在线删除
+var pruning int32
+
+/*
+*删除历史区块的状态,返回删除的树节点数量
+*
+*保留最近retain个区块和checkpoints中的区块的StatTree、TxTree、EventTree和TokenTree,其他的树节点都会被删除
+*已经打包或者正在等待投票的区块也会被保留
+*删除时不持有PackLock,删除过程中可以继续打包和校验区块,新提交的节点不会被删除
+ */
+func (blockchain *BlockChain) Prune(retain int64, checkpoints []int64) (int, error) {
+	if retain <= 0 {
+		return 0, errors.New("Invalid retain")
+	}
+	last := blockchain.GetLastBlock()
+	if last == nil {
+		return 0, errors.New("No block")
+	}
+	heights := make([]int64, 0, retain+int64(len(checkpoints)))
+	for height := last.Height - retain + 1; height < last.Height; height++ {
+		if height > 0 {
+			heights = append(heights, height)
+		}
+	}
+	for _, height := range checkpoints {
+		if height > 0 && height <= last.Height-retain {
+			heights = append(heights, height)
+		}
+	}
+	blocks := append([]*Block{last}, blockchain.pendingBlocks(last.Height)...)
+	for _, height := range heights {
+		block, err := blockchain.GetBlockByHeight(height)
+		if err != nil {
+			return 0, err
+		}
+		blocks = append(blocks, block)
+	}
+	roots := make([][]byte, 0, 4*len(blocks))
+	for _, block := range blocks {
+		for _, root := range [][]byte{block.StatRoot, block.TxRoot, block.EventRoot, block.TokenRoot} {
+			if len(root) > 0 {
+				roots = append(roots, root)
+			}
+		}
+	}
+	return MPTPlus.Prune(blockchain.DB, roots)
+}
+
+// 在线删除,同一时间只会有一个删除任务
+func (blockchain *BlockChain) AutoPrune() {
+	if !atomic.CompareAndSwapInt32(&pruning, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&pruning, 0)
+	count, err := blockchain.Prune(conf.EKTConfig.Prune.Retain, conf.EKTConfig.Prune.Checkpoints)
+	if err != nil {
+		log.GetLogInst().LogCrit("Prune state failed. %v", err)
+		return
+	}
+	log.GetLogInst().LogInfo("Pruned %d trie nodes at height %d.", count, blockchain.GetLastHeight())
+}
+
+// 已经打包或者从其他节点收到但是还没有写入区块链的区块,以及分支上的区块
+func (blockchain *BlockChain) pendingBlocks(lastHeight int64) []*Block {
+	blocks := make([]*Block, 0)
+	blockchain.BlockManager.RLock()
+	for _, block := range blockchain.BlockManager.Blocks {
+		if block.Height > lastHeight {
+			blocks = append(blocks, block)
+		}
+	}
+	blockchain.BlockManager.RUnlock()
+	BlockRecorder.blocks.Range(func(key, value interface{}) bool {
+		if block := value.(*Block); block.Height > lastHeight {
+			blocks = append(blocks, block)
+		}
+		return true
+	})
+	// 分支上的区块在切换分支时需要使用
+	return append(blocks, blockchain.Forks.Blocks()...)
+}
@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+)
+
+// 已经写入区块的交易和执行结果
+type TxReceipt struct {
+	Transaction *common.Transaction `json:"transaction"`
+	TxResult    common.TxResult     `json:"txResult"`
+	Height      int64               `json:"height"`
+	BlockHash   common.HexBytes     `json:"blockHash"`
+	Index       int                 `json:"index"`
+}
+
+// 根据交易的id查询交易、执行结果和所在的区块,交易不在主链上时返回错误
+func (blockchain *BlockChain) GetTxReceipt(txId []byte) (*TxReceipt, error) {
+	location, err := blockchain.GetTxLocation(txId)
+	if err != nil {
+		return nil, errors.New("Not Exist")
+	}
+	block, err := blockchain.GetBlockByHeight(location.Height)
+	if err != nil {
+		return nil, err
+	}
+	body, err := blockchain.GetBlockBody(block)
+	if err != nil {
+		return nil, err
+	}
+	if location.Index >= len(body.TxResults) || body.TxResults[location.Index].TxId != hex.EncodeToString(txId) {
+		return nil, errors.New("Invalid tx index")
+	}
+	data, err := blockchain.DB.Get(txId)
+	if err != nil {
+		return nil, err
+	}
+	tx := common.FromBytes(data)
+	if tx == nil {
+		return nil, errors.New("Invalid transaction")
+	}
+	return &TxReceipt{
+		Transaction: tx,
+		TxResult:    body.TxResults[location.Index],
+		Height:      block.Height,
+		BlockHash:   block.Hash(),
+		Index:       location.Index,
+	}, nil
+}
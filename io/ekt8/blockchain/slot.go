@@ -0,0 +1,156 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+// 一个区块之前某个节点错过的时间槽的数量
+type MissedSlot struct {
+	Height int64    `json:"height,omitempty"` // 错过的时间槽之后产生的区块的高度
+	Peer   p2p.Peer `json:"peer"`
+	Count  int      `json:"count"`
+}
+
+// block之后的出块顺序,当前轮次结束之后的节点和一轮正常结束时相同
+func (blockchain *BlockChain) ScheduleAfter(block *Block) (*i_consensus.Schedule, error) {
+	round, err := RoundAfter(block)
+	if err != nil {
+		return nil, err
+	}
+	next := round.Peers
+	if block.Height > 0 && round.CurrentIndex != round.Len()-1 {
+		if next, err = block.NextPeers(round.Peers); err != nil {
+			return nil, err
+		}
+	}
+	return i_consensus.NewSchedule(round, next, block.Hash(), block.Timestamp, blockchain.BlockInterval), nil
+}
+
+// parent之后timestamp所在的时间槽,高度为1的区块由第一个节点产生
+func (blockchain *BlockChain) SlotAfter(parent *Block, timestamp int64) (i_consensus.Slot, error) {
+	schedule, err := blockchain.ScheduleAfter(parent)
+	if err != nil {
+		return i_consensus.Slot{}, err
+	}
+	if parent.Height == 0 {
+		return schedule.Slot(0), nil
+	}
+	return schedule.SlotAt(timestamp), nil
+}
+
+// 当前区块之后timestamp所在的时间槽
+func (blockchain *BlockChain) CurrentSlot(timestamp int64) (i_consensus.Slot, error) {
+	return blockchain.SlotAfter(blockchain.GetLastBlock(), timestamp)
+}
+
+// 产生block的时间槽,block的Round必须和时间槽的Round相同
+func (blockchain *BlockChain) SlotOf(block *Block) (i_consensus.Slot, error) {
+	parent, err := blockchain.GetParent(block)
+	if err != nil {
+		return i_consensus.Slot{}, err
+	}
+	return blockchain.SlotAfter(parent, block.Timestamp)
+}
+
+/*
+*block之前错过出块的节点,按照第一次错过的顺序
+*
+*父区块之后到block所在的时间槽之前的每一个时间槽都被对应的节点错过了,父区块是创世块时不记录
+ */
+func (blockchain *BlockChain) MissedSlots(block *Block) ([]MissedSlot, error) {
+	parent, err := blockchain.GetParent(block)
+	if err != nil || parent.Height == 0 {
+		return nil, nil
+	}
+	schedule, err := blockchain.ScheduleAfter(parent)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Next.Len() == 0 {
+		return nil, nil
+	}
+	missed := make([]MissedSlot, 0)
+	for i := 0; i < schedule.SlotAt(block.Timestamp).Index; i++ {
+		owner, found := schedule.Slot(i).Owner, false
+		for j := range missed {
+			if missed[j].Peer.Equal(owner) {
+				missed[j].Count++
+				found = true
+				break
+			}
+		}
+		if !found {
+			missed = append(missed, MissedSlot{Height: block.Height, Peer: owner, Count: 1})
+		}
+	}
+	return missed, nil
+}
+
+func (blockchain *BlockChain) MissedSlotsKey(height int64) []byte {
+	return db.MissedSlotNamespace.Key(blockchain.ChainId, db.HeightBytes(height))
+}
+
+// 写入block之前错过的时间槽,没有错过时删除这个高度上原来的记录
+func (blockchain *BlockChain) writeMissedSlots(batch db.Batch, block *Block) error {
+	missed, err := blockchain.MissedSlots(block)
+	if err != nil {
+		return err
+	}
+	if len(missed) == 0 {
+		batch.Delete(blockchain.MissedSlotsKey(block.Height))
+		return nil
+	}
+	value, _ := json.Marshal(missed)
+	batch.Put(blockchain.MissedSlotsKey(block.Height), value)
+	return nil
+}
+
+// 高度在from到to之间(包括from和to)的区块之前错过的时间槽,从from开始按照高度遍历,超过to之后停止
+func (blockchain *BlockChain) GetMissedSlots(from, to int64) ([]MissedSlot, error) {
+	if from < 0 {
+		from = 0
+	}
+	prefix := db.MissedSlotNamespace.Prefix(blockchain.ChainId)
+	iter := blockchain.DB.NewIteratorFrom(prefix, blockchain.MissedSlotsKey(from))
+	defer iter.Release()
+	result := make([]MissedSlot, 0)
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != len(prefix)+8 {
+			continue
+		}
+		if height := int64(binary.BigEndian.Uint64(key[len(prefix):])); height > to {
+			break
+		}
+		missed := make([]MissedSlot, 0)
+		if err := json.Unmarshal(iter.Value(), &missed); err != nil {
+			return nil, err
+		}
+		result = append(result, missed...)
+	}
+	return result, iter.Error()
+}
+
+// 每个节点错过的时间槽的总数,按照第一次错过的顺序
+func CountMissedSlots(slots []MissedSlot) []MissedSlot {
+	total := make([]MissedSlot, 0)
+	for _, slot := range slots {
+		found := false
+		for i := range total {
+			if total[i].Peer.Equal(slot.Peer) {
+				total[i].Count += slot.Count
+				found = true
+				break
+			}
+		}
+		if !found {
+			total = append(total, MissedSlot{Peer: slot.Peer, Count: slot.Count})
+		}
+	}
+	return total
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+func TestMissedSlots(t *testing.T) {
+	store := db.NewMemoryDB()
+	chain := NewBlockChain(store, BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	peers := make([]p2p.Peer, 0)
+	for i, id := range []string{"a", "b", "c"} {
+		peers = append(peers, p2p.Peer{PeerId: id, Address: "127.0.0.1", Port: int32(19951 + i)})
+	}
+	parent := &Block{
+		Height:      5,
+		Timestamp:   1000,
+		CurrentHash: crypto.Sha3_256([]byte("parent")),
+		StatTree:    MPTPlus.NewMTP(store),
+		Round:       &i_consensus.Round{Peers: peers, CurrentIndex: 0},
+		store:       store,
+	}
+	chain.SetLastBlock(parent)
+	chain.SetLastHeight(parent.Height)
+	interval := int64(BackboneBlockInterval / 1e6)
+
+	// 一轮中剩下的节点按照顺序出块,之后是下一轮洗牌之后的节点
+	next := i_consensus.Shuffle(peers, parent.Hash())
+	for index, owner := range []p2p.Peer{peers[1], peers[2], next[0], next[1], next[2], next[0]} {
+		slot, err := chain.CurrentSlot(parent.Timestamp + int64(index)*interval + 1)
+		if err != nil || slot.Index != index || !slot.Owner.Equal(owner) || slot.Deadline != parent.Timestamp+int64(index+1)*interval {
+			fmt.Println("slot", index, slot)
+			t.Fail()
+		}
+		if !slot.Round.Peers[slot.Round.CurrentIndex].Equal(owner) {
+			t.Fail()
+		}
+	}
+
+	// b和c错过了自己的时间槽,由下一轮的第一个节点出块
+	block := &Block{Height: 6, PreviousHash: parent.Hash(), Timestamp: parent.Timestamp + 2*interval + 10}
+	slot, _ := chain.CurrentSlot(block.Timestamp)
+	block.Round = slot.Round
+	if slot, err := chain.SlotOf(block); err != nil || !slot.Round.Equal(block.Round) {
+		fmt.Println("slot of block", slot, err)
+		t.Fail()
+	}
+	missed, err := chain.MissedSlots(block)
+	if err != nil || len(missed) != 2 || !missed[0].Peer.Equal(peers[1]) || !missed[1].Peer.Equal(peers[2]) || missed[0].Count != 1 || missed[0].Height != 6 {
+		fmt.Println("missed slots", missed)
+		t.Fail()
+	}
+	batch := store.NewBatch()
+	chain.writeMissedSlots(batch, block)
+	store.WriteBatch(batch)
+	if slots, err := chain.GetMissedSlots(1, 6); err != nil || len(slots) != 2 {
+		fmt.Println("recorded missed slots", slots, err)
+		t.Fail()
+	}
+	if slots, _ := chain.GetMissedSlots(7, 10); len(slots) != 0 {
+		t.Fail()
+	}
+	if slots, _ := chain.GetMissedSlots(1, 5); len(slots) != 0 {
+		fmt.Println("slots after to", slots)
+		t.Fail()
+	}
+	if slots, _ := chain.GetMissedSlots(6, 6); len(slots) != 2 {
+		t.Fail()
+	}
+	total := CountMissedSlots([]MissedSlot{{Peer: peers[1], Count: 2}, {Peer: peers[2], Count: 1}, {Peer: peers[1], Count: 1}})
+	if len(total) != 2 || total[0].Count != 3 || total[1].Count != 1 {
+		fmt.Println("total", total)
+		t.Fail()
+	}
+
+	// 同一个高度上写入没有错过时间槽的区块时删除原来的记录
+	block.Timestamp = parent.Timestamp + 10
+	batch.Reset()
+	chain.writeMissedSlots(batch, block)
+	store.WriteBatch(batch)
+	if slots, _ := chain.GetMissedSlots(1, 6); len(slots) != 0 {
+		fmt.Println("stale missed slots", slots)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -0,0 +1,272 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+)
+
+const (
+	SnapshotVersion = 1
+	// 导入时每插入多少个key写一次数据库,防止内存占用过多
+	snapshotCommitSize = 10000
+)
+
+var snapshotMagic = []byte("EKTSNAP")
+
+var InvalidSnapshot = errors.New("Invalid snapshot")
+
+type SnapshotHeader struct {
+	Version   int             `json:"version"`
+	Height    int64           `json:"height"`
+	BlockHash common.HexBytes `json:"blockHash"`
+	Block     *Block          `json:"block"`
+	Votes     Votes           `json:"votes"`
+}
+
+/*
+*把区块的四棵树上的所有key/value写入快照
+*
+*快照的格式: magic + header长度 + header + 多条记录 + 0
+*每条记录是: 树的编号(1~4) + key的长度 + key + value的长度 + value,长度都是uvarint
+ */
+func (block *Block) ExportSnapshot(w io.Writer, votes Votes) error {
+	header := SnapshotHeader{
+		Version:   SnapshotVersion,
+		Height:    block.Height,
+		BlockHash: block.Hash(),
+		Block:     block,
+		Votes:     votes,
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(w)
+	writer.Write(snapshotMagic)
+	writeBytes(writer, data)
+	for i, root := range block.roots() {
+		if len(root) == 0 {
+			continue
+		}
+		var werr error
+		err = MPTPlus.MTP_Tree(block.DB(), root).Iterate(nil, nil, func(key, value []byte) bool {
+			if werr = writer.WriteByte(byte(i + 1)); werr == nil {
+				if werr = writeBytes(writer, key); werr == nil {
+					werr = writeBytes(writer, value)
+				}
+			}
+			return werr == nil
+		})
+		if err == nil {
+			err = werr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	writer.WriteByte(0)
+	return writer.Flush()
+}
+
+/*
+*从快照中重建区块的四棵树,重建之后的root必须和区块中的root一致
+*
+*区块的Hash和投票会被校验,但是投票的节点来自区块自己的Round,导入的快照需要来自可信的节点
+ */
+func ImportSnapshot(store db.KVStore, r io.Reader) (*SnapshotHeader, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return nil, InvalidSnapshot
+	}
+	data, err := readBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	var header SnapshotHeader
+	if err = json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if err = header.validate(); err != nil {
+		return nil, err
+	}
+	block := header.Block
+	trees := make([]*MPTPlus.MTP, 4)
+	for i := range trees {
+		trees[i] = MPTPlus.NewMTP(store)
+		trees[i].StartBatch()
+	}
+	counts := make([]int, len(trees))
+	for {
+		index, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if index == 0 {
+			break
+		}
+		if int(index) > len(trees) {
+			return nil, InvalidSnapshot
+		}
+		key, err := readBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+		tree := trees[index-1]
+		if err = tree.MustInsert(key, value); err != nil {
+			return nil, err
+		}
+		if counts[index-1]++; counts[index-1]%snapshotCommitSize == 0 {
+			if err = tree.Commit(); err != nil {
+				return nil, err
+			}
+			tree.StartBatch()
+		}
+	}
+	for i, root := range block.roots() {
+		if err = trees[i].Commit(); err != nil {
+			return nil, err
+		}
+		if len(root) == 0 {
+			// 区块中没有这棵树,快照中也不能有这棵树的数据
+			if counts[i] > 0 {
+				return nil, errors.New("Root mismatch")
+			}
+			trees[i] = MPTPlus.MTP_Tree(store, nil)
+		} else if !bytes.Equal(root, trees[i].Root) {
+			return nil, errors.New("Root mismatch")
+		}
+	}
+	block.StatTree, block.TxTree, block.EventTree, block.TokenTree = trees[0], trees[1], trees[2], trees[3]
+	block.store = store
+	return &header, nil
+}
+
+func (header *SnapshotHeader) validate() error {
+	block := header.Block
+	if header.Version != SnapshotVersion || block == nil || block.Height != header.Height || block.Height <= 0 || block.Round == nil {
+		return InvalidSnapshot
+	}
+	if !bytes.Equal(block.CurrentHash, header.BlockHash) || !bytes.Equal(block.CaculateHash(), header.BlockHash) {
+		return errors.New("Block hash mismatch")
+	}
+	if !header.Votes.Validate() {
+		return errors.New("Invalid votes")
+	}
+	if header.Votes.Len() > 0 && !bytes.Equal(header.Votes[0].BlockHash, header.BlockHash) {
+		return errors.New("Invalid votes")
+	}
+	// 和分叉选择一样只计数轮次中不同节点的投票,同一个节点的多个投票只算一次
+	if !header.Votes.ValidateVoters(block.GetRound()) {
+		return errors.New("Not enough votes")
+	}
+	return nil
+}
+
+// 导出指定高度的区块的状态快照,快照中包含区块和区块的投票结果
+func (blockchain *BlockChain) ExportSnapshot(w io.Writer, height int64) error {
+	block, err := blockchain.GetBlockByHeight(height)
+	if err != nil {
+		return err
+	}
+	votes := blockchain.getVotes(block.Hash())
+	if len(votes) == 0 {
+		return errors.New("Votes not found")
+	}
+	return block.ExportSnapshot(w, votes)
+}
+
+// 导入快照,导入之后当前区块是快照中的区块
+func (blockchain *BlockChain) ImportSnapshot(r io.Reader) (*Block, error) {
+	header, err := ImportSnapshot(blockchain.DB, r)
+	if err != nil {
+		return nil, err
+	}
+	if err = blockchain.SaveSnapshotBlock(header.Block, header.Votes); err != nil {
+		return nil, err
+	}
+	return header.Block, nil
+}
+
+func (blockchain *BlockChain) ImportSnapshotFile(path string) (*Block, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return blockchain.ImportSnapshot(file)
+}
+
+// 把快照中的区块作为当前区块,之后从下一个高度开始同步
+func (blockchain *BlockChain) SaveSnapshotBlock(block *Block, votes Votes) error {
+	blockchain.Locker.Lock()
+	defer blockchain.Locker.Unlock()
+	batch, err := blockchain.commitBatch(block, votes)
+	if err != nil {
+		return err
+	}
+	batch.Put(blockchain.SnapshotHeightKey(), []byte(strconv.FormatInt(block.Height, 10)))
+	if err = blockchain.DB.WriteBatch(batch); err != nil {
+		return err
+	}
+	blockchain.SetLastBlock(block)
+	blockchain.SetLastHeight(block.Height)
+	return nil
+}
+
+func (blockchain *BlockChain) SnapshotHeightKey() []byte {
+	return db.SnapshotNamespace.Key(blockchain.ChainId)
+}
+
+// 本地保存的第一个区块的高度,从快照启动的节点是快照的高度
+func (blockchain *BlockChain) FirstHeight() int64 {
+	data, err := blockchain.DB.Get(blockchain.SnapshotHeightKey())
+	if err != nil || len(data) == 0 {
+		return 1
+	}
+	height, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 1
+	}
+	return height
+}
+
+func (block *Block) roots() [][]byte {
+	return [][]byte{block.StatRoot, block.TxRoot, block.EventRoot, block.TokenRoot}
+}
+
+func writeBytes(writer *bufio.Writer, data []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	if _, err := writer.Write(buf[:binary.PutUvarint(buf, uint64(len(data)))]); err != nil {
+		return err
+	}
+	_, err := writer.Write(data)
+	return err
+}
+
+func readBytes(reader *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > 64<<20 {
+		return nil, InvalidSnapshot
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
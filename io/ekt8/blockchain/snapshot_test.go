@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+func TestSnapshot(t *testing.T) {
+	from, to := db.NewMemoryDB(), db.NewMemoryDB()
+	block := &Block{
+		Height:    10,
+		StatTree:  MPTPlus.NewMTP(from),
+		TxTree:    MPTPlus.NewMTP(from),
+		EventTree: MPTPlus.NewMTP(from),
+		Round:     &i_consensus.Round{CurrentIndex: 0},
+		store:     from,
+	}
+	for i := 0; i < 10; i++ {
+		key := crypto.Sha3_256([]byte(fmt.Sprint("account", i)))
+		block.StatTree.MustInsert(key, []byte(fmt.Sprint("value", i)))
+	}
+	block.TxTree.MustInsert([]byte("tx"), []byte("tx value"))
+	privKeys := make([][]byte, 0)
+	for i := 0; i < 3; i++ {
+		pub, priv := crypto.GenerateKeyPair()
+		privKeys = append(privKeys, priv)
+		block.Round.Peers = append(block.Round.Peers, p2p.Peer{PeerId: hex.EncodeToString(crypto.Sha3_256(pub)), Address: "127.0.0.1", Port: int32(19951 + i)})
+	}
+	block.UpdateMPTPlusRoot()
+	block.CaculateHash()
+	votes := make(Votes, 0)
+	for i, priv := range privKeys[:2] {
+		vote := BlockVote{BlockHash: block.Hash(), BlockHeight: block.Height, VoteResult: true, Peer: block.Round.Peers[i]}
+		vote.Sign(priv)
+		votes = append(votes, vote)
+	}
+
+	buffer := bytes.Buffer{}
+	if err := block.ExportSnapshot(&buffer, votes); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	data := buffer.Bytes()
+	header, err := ImportSnapshot(to, bytes.NewReader(data))
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if header.Height != block.Height || !bytes.Equal(header.Block.StatRoot, block.StatRoot) || len(header.Block.TokenRoot) != 0 {
+		t.Fail()
+	}
+	value, err := header.Block.StatTree.GetValue(crypto.Sha3_256([]byte("account3")))
+	if err != nil || string(value) != "value3" {
+		t.Fail()
+	}
+
+	// 修改快照中的数据之后root不一致
+	tampered := bytes.Replace(data, []byte("value3"), []byte("value4"), 1)
+	if _, err = ImportSnapshot(db.NewMemoryDB(), bytes.NewReader(tampered)); err == nil {
+		t.Fail()
+	}
+	// 投票不足
+	buffer.Reset()
+	block.ExportSnapshot(&buffer, votes[:1])
+	if _, err = ImportSnapshot(db.NewMemoryDB(), &buffer); err == nil {
+		t.Fail()
+	}
+
+	// 同一个节点签名的两个不同的投票只算一个节点
+	twice := votes[0]
+	twice.BlockHeight++
+	twice.Sign(privKeys[0])
+	buffer.Reset()
+	block.ExportSnapshot(&buffer, Votes{votes[0], twice})
+	if _, err = ImportSnapshot(db.NewMemoryDB(), &buffer); err == nil {
+		fmt.Println("snapshot with duplicate voter imported")
+		t.Fail()
+	}
+
+	// 链导入快照之后可以再导出这个高度的快照
+	chain := NewBlockChain(db.NewMemoryDB(), BackboneChainId, BackboneConsensus, BackboneChainFee, BackboneChainDifficulty, BackboneBlockInterval)
+	buffer.Reset()
+	block.ExportSnapshot(&buffer, votes)
+	if imported, err := chain.ImportSnapshot(&buffer); err != nil || chain.GetLastHeight() != 10 || !bytes.Equal(imported.Hash(), block.Hash()) {
+		fmt.Println("import snapshot into chain", err)
+		t.FailNow()
+	}
+	buffer.Reset()
+	if err = chain.ExportSnapshot(&buffer, 10); err != nil {
+		fmt.Println("export snapshot from chain", err)
+		t.FailNow()
+	}
+	if _, err = ImportSnapshot(db.NewMemoryDB(), &buffer); err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+	if err = chain.ExportSnapshot(&buffer, 9); err == nil {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/EducationEKT/EKT/io/ekt8/b_search"
 	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
 	"github.com/EducationEKT/EKT/io/ekt8/p2p"
 )
 
@@ -157,6 +158,33 @@ func (votes Votes) Validate() bool {
 	return true
 }
 
+/*
+*投票是否来自round中超过一半的节点
+*
+*节点的地址和PeerId都需要和round中的节点一致,不在round中的节点的投票不计数,所有投票必须是同一个区块的投票
+ */
+func (votes Votes) ValidateVoters(round *i_consensus.Round) bool {
+	for _, vote := range votes {
+		if !bytes.Equal(vote.BlockHash, votes[0].BlockHash) {
+			return false
+		}
+	}
+	return votes.CountVoters(round) > len(round.Peers)/2
+}
+
+// 投票来自round中多少个不同的节点,同一个节点的多次投票和不在round中的节点的投票都不计数
+func (votes Votes) CountVoters(round *i_consensus.Round) int {
+	voters := make(map[string]bool)
+	for _, vote := range votes {
+		for _, peer := range round.Peers {
+			if peer.Equal(vote.Peer) && strings.EqualFold(peer.PeerId, vote.Peer.PeerId) {
+				voters[strings.ToLower(peer.PeerId)] = true
+			}
+		}
+	}
+	return len(voters)
+}
+
 func (vote Votes) Index(index int) b_search.Interface {
 	if index > vote.Len() || index < 0 {
 		panic("Index out of bound.")
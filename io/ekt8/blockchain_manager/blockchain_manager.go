@@ -3,58 +3,101 @@ package blockchain_manager
 import (
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 
 	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
 	"github.com/EducationEKT/EKT/io/ekt8/consensus"
 	"github.com/EducationEKT/EKT/io/ekt8/db"
-	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
-)
-
-const (
-	BlockchainManagerDBKey = "BlockchainManagerDBKey"
 )
 
 var MainBlockChain *blockchain.BlockChain
-var MainBlockChainConsensus *consensus.DPOSConsensus
+var MainBlockChainConsensus consensus.Engine
 
 var blockchainManager *BlockchainManager
 
 type BlockchainManager struct {
 	Blockchains map[string]*blockchain.BlockChain
-	Consensuses map[string]i_consensus.Consensus
+	Consensuses map[string]consensus.Engine
 }
 
-func Init() {
+// 启动主链和子链的共识,主链上次退出时没有完成的区块写入无法修复、主链的共识没有注册或者无法恢复当前区块时返回错误,节点不能启动
+func Init() error {
 	blockchainManager = &BlockchainManager{
 		Blockchains: make(map[string]*blockchain.BlockChain),
-		Consensuses: make(map[string]i_consensus.Consensus),
+		Consensuses: make(map[string]consensus.Engine),
+	}
+	MainBlockChain = NewMainChain()
+	if err := repairHead(MainBlockChain); err != nil {
+		return err
+	}
+	// 主链的共识没有注册时不能启动节点
+	engine, err := consensus.NewEngine(MainBlockChain)
+	if err != nil {
+		return err
+	}
+	if err = engine.RecoverFromDB(); err != nil {
+		return err
 	}
-	MainBlockChain = blockchain.NewBlockChain(blockchain.BackboneChainId, blockchain.BackboneConsensus, blockchain.BackboneChainFee, blockchain.BackboneChainDifficulty, blockchain.BackboneBlockInterval)
-	MainBlockChainConsensus = consensus.NewDPoSConsensus(MainBlockChain)
+	MainBlockChainConsensus = engine
 	go MainBlockChainConsensus.Run()
-	value, err := db.GetDBInst().Get([]byte(BlockchainManagerDBKey))
+	value, err := db.GetDBInst().Get(db.ChainsNamespace.Key())
 	if err != nil {
-		return
+		return nil
 	}
 	blockchains := make([]*blockchain.BlockChain, 0)
 	err = json.Unmarshal(value, &blockchains)
 	if err != nil {
-		return
+		return nil
 	}
-	for _, blockchain := range blockchains {
-		chainId := hex.EncodeToString(blockchain.ChainId)
-		blockchainManager.Blockchains[chainId] = blockchain
-		switch blockchain.Consensus {
-		case i_consensus.DPOS:
-			consensus := consensus.NewDPoSConsensus(blockchain)
-			blockchainManager.Consensuses[chainId] = consensus
-			go consensus.Run()
-		default:
-			consensus := consensus.NewDPoSConsensus(blockchain)
-			blockchainManager.Consensuses[chainId] = consensus
-			go consensus.Run()
+	for _, chain := range blockchains {
+		chain.DB = db.GetDBInst()
+		chain.Forks = blockchain.NewBlockTree()
+		chainId := hex.EncodeToString(chain.ChainId)
+		blockchainManager.Blockchains[chainId] = chain
+		if err := repairHead(chain); err != nil {
+			fmt.Printf("Consensus of chain %s is not started, %v. \n", chainId, err)
+			continue
 		}
+		engine, err := consensus.NewEngine(chain)
+		if err != nil {
+			fmt.Printf("Consensus of chain %s is not started, %v. \n", chainId, err)
+			continue
+		}
+		if err := engine.RecoverFromDB(); err != nil {
+			fmt.Printf("Consensus of chain %s is not started, %v. \n", chainId, err)
+			continue
+		}
+		blockchainManager.Consensuses[chainId] = engine
+		go engine.Run()
 	}
+	return nil
+}
+
+func repairHead(chain *blockchain.BlockChain) error {
+	repaired, err := chain.RepairHead()
+	if err != nil {
+		return fmt.Errorf("Repair current block failed, %v", err)
+	}
+	if repaired {
+		fmt.Println("Repaired an unfinished block commit.")
+	}
+	return nil
+}
+
+func NewMainChain() *blockchain.BlockChain {
+	return blockchain.NewBlockChain(db.GetDBInst(), blockchain.BackboneChainId, blockchain.BackboneConsensus, blockchain.BackboneChainFee, blockchain.BackboneChainDifficulty, blockchain.BackboneBlockInterval)
+}
+
+// 从数据库中加载主链的最新区块,不启动共识,给离线命令使用
+func LoadMainChain() (*blockchain.BlockChain, error) {
+	MainBlockChain = NewMainChain()
+	block, err := MainBlockChain.LastBlock()
+	if err != nil {
+		return nil, err
+	}
+	MainBlockChain.SetLastBlock(block)
+	MainBlockChain.SetLastHeight(block.Height)
+	return MainBlockChain, nil
 }
 
 func GetManagerInst() *BlockchainManager {
@@ -65,6 +108,11 @@ func GetMainChain() *blockchain.BlockChain {
 	return MainBlockChain
 }
 
-func GetMainChainConsensus() *consensus.DPOSConsensus {
+func GetMainChainConsensus() consensus.Engine {
 	return MainBlockChainConsensus
 }
+
+// 子链的共识引擎,链不存在时返回nil
+func (manager *BlockchainManager) GetConsensus(chainId string) consensus.Engine {
+	return manager.Consensuses[chainId]
+}
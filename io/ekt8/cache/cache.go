@@ -3,26 +3,26 @@ package cache
 import "github.com/EducationEKT/EKT/io/ekt8/db"
 
 var (
-	CurrentBlockKey = []byte("MainBlockchainCurrentBlockKey")
-	InitedKey       = []byte("inited")
+	CurrentBlockKey = db.CacheNamespace.Key([]byte("currentBlock"))
+	InitedKey       = db.CacheNamespace.Key([]byte("inited"))
 )
 
-func CurrentBlock() ([]byte, error) {
-	return db.GetDBInst().Get(CurrentBlockKey)
+func CurrentBlock(store db.KVStore) ([]byte, error) {
+	return store.Get(CurrentBlockKey)
 }
 
-func SetCurrentBlock(hash []byte) {
-	db.GetDBInst().Set(CurrentBlockKey, hash)
+func SetCurrentBlock(store db.KVStore, hash []byte) {
+	store.Set(CurrentBlockKey, hash)
 }
 
-func IsInited() bool {
-	v, err := db.GetDBInst().Get(InitedKey)
+func IsInited(store db.KVStore) bool {
+	v, err := store.Get(InitedKey)
 	if err != nil || len(v) == 0 {
 		return false
 	}
 	return true
 }
 
-func GenesisBlockInit() {
-	db.GetDBInst().Set(InitedKey, InitedKey)
+func GenesisBlockInit(store db.KVStore) {
+	store.Set(InitedKey, InitedKey)
 }
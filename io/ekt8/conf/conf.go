@@ -17,6 +17,16 @@ type EKTConf struct {
 	GenesisBlockAccounts []common.Account `json:"genesisBlock"`
 	PrivateKey           []byte           `json:"privateKey"`
 	Env                  string           `json:"env"`
+	TrieEncoding         string           `json:"trieEncoding"` // 链上所有节点必须一致,json或者rlp,默认是json
+	Prune                PruneConf        `json:"prune"`
+	Snapshot             string           `json:"snapshot"`   // 第一次启动时从这个快照文件开始同步
+	Indexer              string           `json:"indexer"`    // 交易索引的SQLite数据库路径,为空时不开启索引
+	Governance           string           `json:"governance"` // 可以安排出块节点变更的账户地址,只在写入创世块时使用
+}
+
+type PruneConf struct {
+	Retain      int64   `json:"retain"`      // 保留最近多少个区块的状态,0表示不删除历史状态
+	Checkpoints []int64 `json:"checkpoints"` // 需要一直保留状态的区块高度
 }
 
 var EKTConfig EKTConf
@@ -1,13 +1,11 @@
 package consensus
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"sort"
-
-	"xserver/x_http/x_resp"
-
 	"sync"
 	"time"
 
@@ -15,16 +13,23 @@ import (
 	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
 	"github.com/EducationEKT/EKT/io/ekt8/conf"
 	"github.com/EducationEKT/EKT/io/ekt8/context_log"
-	"github.com/EducationEKT/EKT/io/ekt8/db"
 	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
 	"github.com/EducationEKT/EKT/io/ekt8/log"
 	"github.com/EducationEKT/EKT/io/ekt8/p2p"
-	"github.com/EducationEKT/EKT/io/ekt8/param"
-	"github.com/EducationEKT/EKT/io/ekt8/util"
 )
 
+// 区块的时间最多比本地时间晚多少毫秒
+const MaxClockDrift = 500
+
+func init() {
+	Register(i_consensus.DPOS, func(chain *blockchain.BlockChain) Engine {
+		return NewDPoSConsensus(chain)
+	})
+}
+
 type DPOSConsensus struct {
 	Blockchain  *blockchain.BlockChain
+	Network     Network
 	Block       chan blockchain.Block
 	Vote        chan blockchain.BlockVote
 	VoteResults blockchain.VoteResults
@@ -35,6 +40,7 @@ type DPOSConsensus struct {
 func NewDPoSConsensus(Blockchain *blockchain.BlockChain) *DPOSConsensus {
 	return &DPOSConsensus{
 		Blockchain:  Blockchain,
+		Network:     HTTPNetwork{},
 		Block:       make(chan blockchain.Block),
 		Vote:        make(chan blockchain.BlockVote),
 		VoteResults: blockchain.NewVoteResults(),
@@ -46,16 +52,9 @@ func NewDPoSConsensus(Blockchain *blockchain.BlockChain) *DPOSConsensus {
 func (dpos DPOSConsensus) BlockFromPeer(cLog *context_log.ContextLog, block blockchain.Block) {
 	dpos.Locker.Lock()
 	defer dpos.Locker.Unlock()
-	if int(time.Now().UnixNano()/1e6-block.Timestamp) > int(dpos.Blockchain.BlockInterval/1e6) {
-		fmt.Println(time.Now().UnixNano()/1e6, block.Timestamp, int(dpos.Blockchain.BlockInterval/1e6))
-		fmt.Println("Recieved a block packed before 1 second, return.")
-		cLog.Log("More than 1 second", true)
-		return
-	}
-	fmt.Println("Validating is the right node.")
-	if !dpos.PeerTurn(cLog, block.Timestamp, dpos.Blockchain.GetLastBlock().Timestamp, block.GetRound().Peers[block.GetRound().CurrentIndex]) {
-		fmt.Println("This is not the right node, return false.")
-		cLog.Log("Right Node?", false)
+	if err := dpos.VerifyHeader(&block); err != nil {
+		fmt.Printf("Verify block header failed, %v. \n", err)
+		cLog.Log("Verify header", err.Error())
 		return
 	}
 	fmt.Println("This block has the right.")
@@ -66,6 +65,36 @@ func (dpos DPOSConsensus) BlockFromPeer(cLog *context_log.ContextLog, block bloc
 	}
 }
 
+/*
+*校验区块的时间和出块节点
+*
+*区块的时间不能早于一个BlockInterval,也不能晚于本地时间MaxClockDrift,提前的时间会让节点占用下一个节点的时间槽
+*区块的Round必须和区块的时间所在的时间槽一致,包括节点、节点的顺序和出块的位置
+ */
+func (dpos *DPOSConsensus) VerifyHeader(block *blockchain.Block) error {
+	now := time.Now().UnixNano() / 1e6
+	if now-block.Timestamp > int64(dpos.Blockchain.BlockInterval/1e6) {
+		return errors.New("Block is packed more than an interval ago")
+	}
+	if block.Timestamp-now > MaxClockDrift {
+		return errors.New("Block is packed in the future")
+	}
+	slot, err := dpos.Blockchain.SlotOf(block)
+	if err != nil {
+		return err
+	}
+	if slot.Round == nil || block.Round == nil || !slot.Round.Equal(block.GetRound()) {
+		return errors.New("Not the right node")
+	}
+	return nil
+}
+
+// 计算区块的Hash并用当前节点的私钥签名
+func (dpos *DPOSConsensus) Seal(block *blockchain.Block) error {
+	block.CaculateHash()
+	return block.Sign()
+}
+
 func (dpos DPOSConsensus) SendVote(block blockchain.Block) {
 	fmt.Println("Validating send vote interval.")
 	if time.Now().UnixNano()/1e6-dpos.Blockchain.BlockManager.GetVoteTime(block.Height) < int64(dpos.Blockchain.BlockInterval/1e6) {
@@ -92,8 +121,7 @@ func (dpos DPOSConsensus) SendVote(block blockchain.Block) {
 	fmt.Println("Signed this vote, sending vote result to other peers.")
 	for i, peer := range block.GetRound().Peers {
 		if (i-block.GetRound().CurrentIndex+len(block.GetRound().Peers))%len(block.GetRound().Peers) <= len(block.GetRound().Peers)/2 {
-			url := fmt.Sprintf(`http://%s:%d/vote/api/vote`, peer.Address, peer.Port)
-			util.HttpPost(url, vote.Bytes())
+			dpos.Network.SendVote(peer, vote)
 		}
 	}
 }
@@ -113,11 +141,8 @@ func (dpos *DPOSConsensus) Run() {
 
 func (dpos DPOSConsensus) DelegateRun() {
 	fmt.Println("DPoS started.")
-	round := &i_consensus.Round{Peers: param.MainChainDPosNode, CurrentIndex: -1}
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		round = dpos.Blockchain.GetLastBlock().GetRound()
-	}
-	if AliveDPoSPeerCount(round.Peers, false) <= len(round.Peers)/2 {
+	peers := dpos.GetCurrentDPOSPeers()
+	if AliveDPoSPeerCount(peers, false) <= len(peers)/2 {
 		fmt.Println("Alive node is less than half, waiting for other DPoS node restart.")
 		time.Sleep(3 * time.Second)
 	}
@@ -135,8 +160,8 @@ func (dpos DPOSConsensus) DelegateRun() {
 			log.GetLogInst().LogInfo("This is my turn, current height is %d. \n", dpos.Blockchain.GetLastHeight())
 			log.GetLogInst().LogDebug("This is my turn, current height is %d. \n", dpos.Blockchain.GetLastHeight())
 			dpos.Pack()
-			//time.Sleep(dpos.Blockchain.BlockInterval)
-			time.Sleep(time.Duration(int64(dpos.Blockchain.BlockInterval) * int64(len(round.Peers)-1)))
+			// 一个时间槽只打包一次,之后继续检查是否需要接替错过出块的节点
+			time.Sleep(dpos.Blockchain.BlockInterval)
 		} else {
 			log.GetLogInst().LogInfo("No, sleeping %d nano second.", interval)
 			time.Sleep(interval)
@@ -144,82 +169,30 @@ func (dpos DPOSConsensus) DelegateRun() {
 	}
 }
 
-func (dpos DPOSConsensus) PeerTurn(cLog *context_log.ContextLog, packTime, lastBlockTime int64, peer p2p.Peer) bool {
+/*
+*peer是否是packTime所在的时间槽的出块节点
+*
+*时间槽由当前区块的时间和BlockInterval决定,节点在时间槽的Deadline之前没有出块时由下一个时间槽的节点出块,见i_consensus.Schedule
+ */
+func (dpos DPOSConsensus) PeerTurn(cLog *context_log.ContextLog, packTime int64, peer p2p.Peer) bool {
 	fmt.Println("Validating peer has the right to pack block.")
-	round := &i_consensus.Round{
-		Peers:        param.MainChainDPosNode,
-		CurrentIndex: -1,
-	}
 	dpos.Blockchain.Locker.RLock()
 	defer dpos.Blockchain.Locker.RUnlock()
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		cLog.Log("currentHeight", dpos.Blockchain.GetLastHeight())
-		round = dpos.Blockchain.GetLastBlock().GetRound()
-	} else {
-		fmt.Println("Current height is 0, waiting for the first node pack block.")
-		if round.Peers[0].Equal(peer) {
-			fmt.Println("This is the first node, return true.")
-			cLog.Log("result", true)
-			return true
-		} else {
-			cLog.Log("result", false)
-			fmt.Println("This is not the first node, return true.")
-			return false
-		}
-	}
-	cLog.Log("lastRound", round)
-	cLog.Log("This node", peer)
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		if round.NextPeerRight(peer, dpos.Blockchain.GetLastBlock().CurrentHash) {
-			cLog.Log("result", true)
-			return true
-		}
+	cLog.Log("currentHeight", dpos.Blockchain.GetLastHeight())
+	slot, err := dpos.Blockchain.CurrentSlot(packTime)
+	if err != nil {
+		fmt.Printf("Read current slot failed, %v. \n", err)
 		cLog.Log("result", false)
 		return false
 	}
-	cLog.Log("lastRound", round)
-	time, interval := int(packTime-lastBlockTime), int(dpos.Blockchain.BlockInterval/1e6)
-	cLog.LogTiming("packTime", packTime)
-	cLog.LogTiming("lastBlockTime", lastBlockTime)
-	cLog.Log("CurrentNode", peer)
-	if time >= interval*round.Len() {
-		cLog.Log("Time More than a round time", true)
-		fmt.Println("More than a round time, waiting for the next node pack block.")
-		if round.NextPeerRight(peer, dpos.Blockchain.GetLastBlock().CurrentHash) {
-			fmt.Println("This is the next node, return true.")
-			cLog.Log("result", true)
-			return true
-		} else {
-			fmt.Println("This is not the next node, return false.")
-			return false
-		}
-	} else {
-		n := int(time) / int(interval)
-		remainder := int(time) % int(interval)
-		if remainder > int(interval)/2 {
-			n++
-		}
-		if n == 0 {
-			cLog.Log("Less than an interval", true)
-			return false
-		}
-		n++
-		fmt.Printf("Current round is %s \n", round.String())
-		if round.CurrentIndex+n >= round.Len() {
-			round = round.NewRandom(dpos.Blockchain.GetLastBlock().CurrentHash)
-			sort.Sort(round)
-		}
-		round.CurrentIndex = (round.CurrentIndex + n) % round.Len()
-		fmt.Printf("Next round is %s, is my turn? \n", round.String())
-		if round.Peers[round.CurrentIndex].Equal(peer) {
-			fmt.Println("This is the next node, return true.")
-			cLog.Log("result", true)
-			return true
-		} else {
-			fmt.Println("This is not the next node, return false.")
-			return false
-		}
+	cLog.Log("slot", slot)
+	cLog.Log("This node", peer)
+	if slot.Owner.Equal(peer) {
+		fmt.Printf("This is the owner of slot %d, return true.\n", slot.Index)
+		cLog.Log("result", true)
+		return true
 	}
+	cLog.Log("result", false)
 	return false
 }
 
@@ -227,13 +200,11 @@ func (dpos DPOSConsensus) IsMyTurn() bool {
 	//return false
 	cLog := context_log.NewContextLog("DPoS is my turn ?")
 	defer cLog.Finish()
-	return dpos.PeerTurn(cLog, time.Now().UnixNano()/1e6, dpos.Blockchain.GetLastBlock().Timestamp, conf.EKTConfig.Node)
+	return dpos.PeerTurn(cLog, time.Now().UnixNano()/1e6, conf.EKTConfig.Node)
 }
 
 func (dpos *DPOSConsensus) RUN() {
-	// 从数据库中恢复当前节点已同步的区块
-	fmt.Println("Recover data from local database.")
-	dpos.RecoverFromDB()
+	// 当前节点已同步的区块在启动共识之前已经由blockchain_manager调用RecoverFromDB恢复
 	fmt.Printf("Local data recovered. Current height is %d.\n", dpos.Blockchain.GetLastHeight())
 
 	//获取21个节点的集合
@@ -274,12 +245,10 @@ WaitingNodes:
 		} else {
 			log.GetLogInst().LogInfo("Synchronize block at height %d failed.", height)
 			fmt.Printf("Synchronizing block at height %d failed. \n", height)
-			round := &i_consensus.Round{
-				Peers:        param.MainChainDPosNode,
-				CurrentIndex: -1,
-			}
-			if dpos.Blockchain.GetLastHeight() > 0 {
-				round = dpos.Blockchain.GetLastBlock().GetRound()
+			round, err := dpos.Blockchain.CurrentRound()
+			if err != nil {
+				fmt.Printf("Read current round failed, %v. \n", err)
+				goto WaitingNodes
 			}
 			if AliveDPoSPeerCount(peers, false) <= len(round.Peers)/2 {
 				goto WaitingNodes
@@ -340,14 +309,14 @@ func (dpos *DPOSConsensus) dposSync() {
 func (dpos DPOSConsensus) Pack() {
 	block := dpos.Blockchain.PackSignal(dpos.Blockchain.GetLastHeight() + 1)
 	if block != nil {
-		block.CaculateHash()
+		err := dpos.Seal(block)
 		hash := hex.EncodeToString(block.CurrentHash)
 		dpos.Blockchain.BlockManager.Lock()
 		dpos.Blockchain.BlockManager.Blocks[hash] = block
 		dpos.Blockchain.BlockManager.BlockStatus[hash] = blockchain.BODY_SAVED
 		dpos.Blockchain.BlockManager.HeightManager[block.Height] = block.Timestamp
 		dpos.Blockchain.BlockManager.Unlock()
-		if err := block.Sign(); err != nil {
+		if err != nil {
 			fmt.Println("Sign block failed.", err)
 			log.GetLogInst().LogCrit("Sign block failed. %v", err)
 		} else {
@@ -358,25 +327,25 @@ func (dpos DPOSConsensus) Pack() {
 
 func (dpos DPOSConsensus) broadcastBlock(block *blockchain.Block) {
 	fmt.Println("Broadcasting block to the other peers.")
-	data := block.Bytes()
-	for _, peer := range block.GetRound().Peers {
-		url := fmt.Sprintf(`http://%s:%d/block/api/newBlock`, peer.Address, peer.Port)
-		go util.HttpPost(url, data)
-	}
+	dpos.Network.BroadcastBlock(block.GetRound().Peers, block)
 }
 
-func (dpos DPOSConsensus) BlockMinedCallBack(block *blockchain.Block) {
-	fmt.Println("Mined block, sending block to other dpos  peer.")
-	fmt.Println(dpos.Blockchain.GetLastBlock().GetRound())
-	for _, peer := range block.GetRound().Peers {
-		url := fmt.Sprintf("http://%s:%d/block/api/newBlock", peer.Address, peer.Port)
-		resp, err := util.HttpPost(url, block.Bytes())
-		fmt.Println(string(resp), err)
-	}
-}
-
-func (dpos DPOSConsensus) RecoverFromDB() {
+/*
+*恢复当前区块,没有完成的区块写入在这之前已经由blockchain_manager修复
+*
+*配置了快照但是导入失败时返回错误,不能写入创世块从高度1开始同步,否则之后重启也不会再导入快照
+ */
+func (dpos DPOSConsensus) RecoverFromDB() error {
 	block, err := dpos.Blockchain.LastBlock()
+	// 如果是第一次打开并且配置了快照,从快照中的区块开始同步
+	if (err != nil || block == nil) && conf.EKTConfig.Snapshot != "" {
+		block, err = dpos.Blockchain.ImportSnapshotFile(conf.EKTConfig.Snapshot)
+		if err != nil {
+			log.GetLogInst().LogCrit("Import snapshot failed, %v.", err)
+			return err
+		}
+		fmt.Printf("Snapshot imported, current height is %d. \n", block.Height)
+	}
 	// 如果是第一次打开
 	if err != nil || block == nil {
 		// 将创世块写入数据库
@@ -392,24 +361,31 @@ func (dpos DPOSConsensus) RecoverFromDB() {
 			Body:         nil,
 			Timestamp:    0,
 			Locker:       sync.RWMutex{},
-			StatTree:     MPTPlus.NewMTP(db.GetDBInst()),
+			StatTree:     MPTPlus.NewMTP(dpos.Blockchain.DB),
 			StatRoot:     nil,
-			TxTree:       MPTPlus.NewMTP(db.GetDBInst()),
+			TxTree:       MPTPlus.NewMTP(dpos.Blockchain.DB),
 			TxRoot:       nil,
-			EventTree:    MPTPlus.NewMTP(db.GetDBInst()),
+			EventTree:    MPTPlus.NewMTP(dpos.Blockchain.DB),
 			EventRoot:    nil,
-			TokenTree:    MPTPlus.NewMTP(db.GetDBInst()),
+			TokenTree:    MPTPlus.NewMTP(dpos.Blockchain.DB),
 			TokenRoot:    nil,
 		}
 		for _, account := range accounts {
 			block.InsertAccount(account)
 		}
+		// 治理账户是链上的状态,之后不再读取本地的配置
+		if err := block.SetGovernance(conf.EKTConfig.Governance); err != nil {
+			fmt.Printf("Set governance failed, %v. \n", err)
+		}
 		block.UpdateMPTPlusRoot()
 		block.CaculateHash()
-		dpos.Blockchain.SaveBlock(block)
+		if err := dpos.Blockchain.SaveBlock(block, nil); err != nil {
+			return err
+		}
 	}
 	dpos.Blockchain.SetLastBlock(block)
 	dpos.Blockchain.SetLastHeight(block.Height)
+	return nil
 }
 
 //获取存活的DPOS节点数量
@@ -431,31 +407,27 @@ func (dpos DPOSConsensus) SyncHeight(height int64) bool {
 	if dpos.Blockchain.GetLastHeight() >= height {
 		return true
 	}
-	round := &i_consensus.Round{
-		Peers:        param.MainChainDPosNode,
-		CurrentIndex: -1,
-	}
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		round = dpos.Blockchain.GetLastBlock().GetRound()
-	}
-	peers := param.MainChainDPosNode
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		peers = round.Peers
-	}
-	for _, peer := range peers {
-		block, err := getBlockHeader(peer, height)
+	for _, peer := range dpos.GetCurrentDPOSPeers() {
+		block, err := dpos.Network.GetBlockHeader(peer, height)
 		if err != nil || block.Height != height {
 			fmt.Println("Geting block header by height failed.", err)
 			continue
 		}
-		votes, err := getVotes(peer, hex.EncodeToString(block.CurrentHash))
+		votes, err := dpos.Network.GetVotes(peer, hex.EncodeToString(block.CurrentHash))
 		if err != nil {
 			fmt.Println("Error peer has no votes.", err)
 			continue
 		}
+		if !bytes.Equal(block.PreviousHash, dpos.Blockchain.GetLastBlock().Hash()) {
+			// 这个节点的区块在另外一个分支上
+			if dpos.syncBranch(peer, block, votes) {
+				return true
+			}
+			continue
+		}
 		if votes.Validate() {
 			if dpos.Blockchain.GetLastBlock().ValidateNextBlock(*block, dpos.Blockchain.BlockInterval) {
-				if dpos.RecieveVoteResult(votes) {
+				if dpos.Finalize(votes) {
 					return true
 				} else {
 					continue
@@ -466,6 +438,60 @@ func (dpos DPOSConsensus) SyncHeight(height int64) bool {
 	return false
 }
 
+/*
+*从peer同步block所在的分支,向前获取区块头和投票直到父区块在本地的主链或者分支上,最多获取MaxReorgDepth个区块
+*
+*从最早的区块开始依次校验并写入,是否切换到这个分支由BlockChain的分叉选择决定
+ */
+func (dpos DPOSConsensus) syncBranch(peer p2p.Peer, block *blockchain.Block, votes blockchain.Votes) bool {
+	branch := []*blockchain.Block{block}
+	branchVotes := []blockchain.Votes{votes}
+	for {
+		first := branch[0]
+		if _, err := dpos.Blockchain.GetParent(first); err == nil {
+			break
+		}
+		if len(branch) >= blockchain.MaxReorgDepth || first.Height <= 1 {
+			fmt.Println("Can not find the common ancestor of this branch, abort.")
+			return false
+		}
+		parent, err := dpos.Network.GetBlockHeader(peer, first.Height-1)
+		if err != nil || !bytes.Equal(parent.Hash(), first.PreviousHash) {
+			fmt.Println("Geting parent block header failed.", err)
+			return false
+		}
+		parentVotes, err := dpos.Network.GetVotes(peer, hex.EncodeToString(parent.CurrentHash))
+		if err != nil {
+			fmt.Println("Error peer has no votes.", err)
+			return false
+		}
+		branch = append([]*blockchain.Block{parent}, branch...)
+		branchVotes = append([]blockchain.Votes{parentVotes}, branchVotes...)
+	}
+	for i, next := range branch {
+		if !dpos.validateBlockVotes(next, branchVotes[i]) {
+			fmt.Println("Votes of this branch validate failed.")
+			return false
+		}
+		parent, err := dpos.Blockchain.GetParent(next)
+		if err != nil || !parent.ValidateNextBlock(*next, dpos.Blockchain.BlockInterval) {
+			fmt.Println("Block of this branch validate failed.")
+			return false
+		}
+		// 校验之后BlockRecorder中的区块包含区块体
+		hash := hex.EncodeToString(next.CurrentHash)
+		if recorded := blockchain.BlockRecorder.GetBlock(hash); recorded != nil {
+			next = recorded
+		}
+		if err = dpos.Blockchain.AddBlock(next, branchVotes[i]); err != nil {
+			fmt.Printf("Save block failed, %v. \n", err)
+			return false
+		}
+		blockchain.BlockRecorder.SetStatus(hash, 200)
+	}
+	return true
+}
+
 func (dpos DPOSConsensus) VoteFromPeer(vote blockchain.BlockVote) {
 	fmt.Println("Recieved vote from peer.")
 	if dpos.VoteResults.Broadcasted(vote.BlockHash) {
@@ -473,21 +499,18 @@ func (dpos DPOSConsensus) VoteFromPeer(vote blockchain.BlockVote) {
 		return
 	}
 	dpos.VoteResults.Insert(vote)
-	round := &i_consensus.Round{
-		Peers:        param.MainChainDPosNode,
-		CurrentIndex: -1,
-	}
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		round = dpos.Blockchain.GetLastBlock().GetRound()
+	round, err := dpos.Blockchain.CurrentRound()
+	if err != nil {
+		fmt.Printf("Read current round failed, %v. \n", err)
+		return
 	}
 	fmt.Println("Is current vote number more than half node?")
 	if dpos.VoteResults.Number(vote.BlockHash) > len(round.Peers)/2 {
 		fmt.Println("Vote number more than half node, sending vote result to other nodes.")
 		votes := dpos.VoteResults.GetVoteResults(hex.EncodeToString(vote.BlockHash))
 		for _, peer := range round.Peers {
-			url := fmt.Sprintf(`http://%s:%d/vote/api/voteResult`, peer.Address, peer.Port)
-			resp, err := util.HttpPost(url, votes.Bytes())
-			log.GetLogInst().LogDebug(`Resp: %s, err: %v`, string(resp), err)
+			err := dpos.Network.SendVoteResult(peer, votes)
+			log.GetLogInst().LogDebug(`Send vote result to %s, err: %v`, peer.String(), err)
 		}
 	} else {
 		fmt.Printf("Current vote results: %s", string(dpos.VoteResults.GetVoteResults(hex.EncodeToString(vote.BlockHash)).Bytes()))
@@ -495,7 +518,8 @@ func (dpos DPOSConsensus) VoteFromPeer(vote blockchain.BlockVote) {
 	}
 }
 
-func (dpos DPOSConsensus) RecieveVoteResult(votes blockchain.Votes) bool {
+// 收到超过一半节点的投票之后写入区块,轮到当前节点时打包下一个区块
+func (dpos *DPOSConsensus) Finalize(votes blockchain.Votes) bool {
 	if !dpos.ValidateVotes(votes) {
 		fmt.Println("Votes validate failed. ", votes)
 		return false
@@ -510,11 +534,16 @@ func (dpos DPOSConsensus) RecieveVoteResult(votes blockchain.Votes) bool {
 		if status == 100 {
 			// 已同步区块body，但是未写入区块链中
 			fmt.Println("Recieve vote result and get this block, saving block.")
-			dpos.SaveVotes(votes)
-			dpos.Blockchain.NotifyPool(block)
-			dpos.Blockchain.SaveBlock(block)
+			// 切换分支时由BlockChain通知交易池
+			if bytes.Equal(block.PreviousHash, dpos.Blockchain.GetLastBlock().Hash()) {
+				dpos.Blockchain.NotifyPool(block)
+			}
+			if err := dpos.Blockchain.AddBlock(block, votes); err != nil {
+				fmt.Printf("Save block failed, %v. \n", err)
+				return false
+			}
 			blockchain.BlockRecorder.SetStatus(hex.EncodeToString(block.CurrentHash), 200)
-			if block.GetRound().NextPeerRight(conf.EKTConfig.Node, block.CurrentHash) {
+			if dpos.IsMyTurn() {
 				dpos.Pack()
 			}
 		} else if status == 200 {
@@ -528,31 +557,54 @@ func (dpos DPOSConsensus) RecieveVoteResult(votes blockchain.Votes) bool {
 	return false
 }
 
+/*
+*校验投票的签名,并且投票来自区块所在轮次超过一半的节点
+*
+*区块所在的轮次由区块的高度决定,出块节点变更之前的区块仍然使用变更之前的节点校验,
+*本地没有这个区块时使用下一个区块的轮次
+ */
 func (dpos DPOSConsensus) ValidateVotes(votes blockchain.Votes) bool {
+	if len(votes) == 0 {
+		return false
+	}
+	block := blockchain.BlockRecorder.GetBlock(hex.EncodeToString(votes[0].BlockHash))
+	if block == nil {
+		block, _ = dpos.Blockchain.GetBlockByHash(votes[0].BlockHash)
+	}
+	return dpos.validateBlockVotes(block, votes)
+}
+
+func (dpos *DPOSConsensus) validateBlockVotes(block *blockchain.Block, votes blockchain.Votes) bool {
 	if !votes.Validate() {
 		return false
 	}
-	round := &i_consensus.Round{
-		Peers:        param.MainChainDPosNode,
-		CurrentIndex: -1,
+	if block != nil && !bytes.Equal(block.Hash(), votes[0].BlockHash) {
+		return false
 	}
-	if dpos.Blockchain.GetLastHeight() > 0 {
-		round = dpos.Blockchain.GetLastBlock().GetRound()
+	// 父区块不在本地时使用当前的轮次,读取选举状态失败时不能通过校验
+	round, err := dpos.Blockchain.CurrentRound()
+	if block != nil {
+		if blockRound, roundErr := dpos.Blockchain.RoundOf(block); roundErr != blockchain.UnknownParent {
+			round, err = blockRound, roundErr
+		}
 	}
-	if votes.Len() <= len(round.Peers)/2 {
+	if err != nil {
+		fmt.Printf("Read round of votes failed, %v. \n", err)
 		return false
 	}
-	return true
+	return votes.ValidateVoters(round)
 }
 
 func (dpos DPOSConsensus) SaveVotes(votes blockchain.Votes) {
-	dbKey := []byte(fmt.Sprintf("block_votes:%s", hex.EncodeToString(votes[0].BlockHash)))
-	db.GetDBInst().Set(dbKey, votes.Bytes())
+	dpos.Blockchain.DB.Set(blockchain.VotesKey(votes[0].BlockHash), votes.Bytes())
 }
 
 func (dpos DPOSConsensus) GetVotes(blockHash string) blockchain.Votes {
-	dbKey := []byte(fmt.Sprintf("block_votes:%s", blockHash))
-	data, err := db.GetDBInst().Get(dbKey)
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		return nil
+	}
+	data, err := dpos.Blockchain.DB.Get(blockchain.VotesKey(hash))
 	if err != nil {
 		return nil
 	}
@@ -564,44 +616,12 @@ func (dpos DPOSConsensus) GetVotes(blockHash string) blockchain.Votes {
 	return votes
 }
 
-//获取当前的peers
+//获取当前的peers,一轮结束之后是选举产生的节点
 func (dpos DPOSConsensus) GetCurrentDPOSPeers() p2p.Peers {
-	return param.MainChainDPosNode
-}
-
-func getBlockHeader(peer p2p.Peer, height int64) (*blockchain.Block, error) {
-	url := fmt.Sprintf(`http://%s:%d/block/api/blockByHeight?height=%d`, peer.Address, peer.Port, height)
-	body, err := util.HttpGet(url)
+	round, err := dpos.Blockchain.CurrentRound()
 	if err != nil {
-		return nil, err
-	}
-	var resp x_resp.XRespBody
-	err = json.Unmarshal(body, &resp)
-	data, err := json.Marshal(resp.Result)
-	if err == nil {
-		var block blockchain.Block
-		err = json.Unmarshal(data, &block)
-		return &block, err
-	}
-	return nil, err
-}
-
-func getVotes(peer p2p.Peer, blockHash string) (blockchain.Votes, error) {
-	url := fmt.Sprintf(`http://%s:%d/vote/api/getVotes?hash=%s`, peer.Address, peer.Port, blockHash)
-	body, err := util.HttpGet(url)
-	if err != nil {
-		return nil, err
-	}
-	var resp x_resp.XRespBody
-	err = json.Unmarshal(body, &resp)
-	if err == nil && resp.Status == 0 {
-		var votes blockchain.Votes
-		data, err := json.Marshal(resp.Result)
-		if err != nil {
-			return nil, err
-		}
-		err = json.Unmarshal(data, &votes)
-		return votes, err
+		fmt.Printf("Read current round failed, %v. \n", err)
+		return nil
 	}
-	return nil, err
+	return round.Peers
 }
@@ -0,0 +1,64 @@
+package consensus
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
+	"github.com/EducationEKT/EKT/io/ekt8/context_log"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+var UnsupportedConsensus = errors.New("Unsupported consensus")
+
+/*
+*共识引擎,决定一条链由哪个节点出块、怎么校验和写入其他节点的区块
+*
+*实现共识的文件在init中调用Register注册,BlockchainManager按照链的Consensus创建共识引擎
+ */
+type Engine interface {
+	i_consensus.Consensus
+	// 从数据库、快照或者创世块恢复当前区块,在Run之前调用,返回错误时不能启动共识
+	RecoverFromDB() error
+	// 校验其他节点产生的区块头,包括出块节点和出块时间,区块的状态由BlockChain校验
+	VerifyHeader(block *blockchain.Block) error
+	// 当前节点打包之后计算Hash并签名,之后可以广播给其他节点
+	Seal(block *blockchain.Block) error
+	// peer是否可以在packTime打包下一个区块
+	PeerTurn(cLog *context_log.ContextLog, packTime int64, peer p2p.Peer) bool
+	// 收到其他节点广播的区块
+	BlockFromPeer(cLog *context_log.ContextLog, block blockchain.Block)
+	// 收到其他节点对区块的投票
+	VoteFromPeer(vote blockchain.BlockVote)
+	// 收到区块的投票结果,校验通过之后把区块写入链中
+	Finalize(votes blockchain.Votes) bool
+	// 已经写入的区块的投票结果
+	GetVotes(blockHash string) blockchain.Votes
+}
+
+// 为一条链创建共识引擎
+type EngineFactory func(chain *blockchain.BlockChain) Engine
+
+var (
+	engines       = make(map[i_consensus.ConsensusType]EngineFactory)
+	enginesLocker sync.RWMutex
+)
+
+// 注册一种共识的引擎,重复注册时覆盖原来的引擎
+func Register(consensusType i_consensus.ConsensusType, factory EngineFactory) {
+	enginesLocker.Lock()
+	defer enginesLocker.Unlock()
+	engines[consensusType] = factory
+}
+
+// 按照链的Consensus创建共识引擎,没有注册时返回UnsupportedConsensus
+func NewEngine(chain *blockchain.BlockChain) (Engine, error) {
+	enginesLocker.RLock()
+	factory, exist := engines[chain.Consensus]
+	enginesLocker.RUnlock()
+	if !exist {
+		return nil, UnsupportedConsensus
+	}
+	return factory(chain), nil
+}
@@ -0,0 +1,45 @@
+package consensus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+)
+
+type testEngine struct {
+	*DPOSConsensus
+}
+
+func TestEngineRegistry(t *testing.T) {
+	store := db.NewMemoryDB()
+	chain := blockchain.NewBlockChain(store, blockchain.BackboneChainId, i_consensus.DPOS, blockchain.BackboneChainFee, blockchain.BackboneChainDifficulty, blockchain.BackboneBlockInterval)
+	engine, err := NewEngine(chain)
+	if dpos, ok := engine.(*DPOSConsensus); err != nil || !ok || dpos.Blockchain != chain {
+		fmt.Println("dpos engine not registered", err)
+		t.Fail()
+	}
+
+	chain.Consensus = i_consensus.POW
+	if _, err = NewEngine(chain); err != UnsupportedConsensus {
+		fmt.Println("unregistered consensus", err)
+		t.Fail()
+	}
+	Register(i_consensus.POW, func(chain *blockchain.BlockChain) Engine {
+		return testEngine{NewDPoSConsensus(chain)}
+	})
+	defer func() {
+		enginesLocker.Lock()
+		delete(engines, i_consensus.POW)
+		enginesLocker.Unlock()
+	}()
+	if engine, err = NewEngine(chain); err != nil {
+		fmt.Println(err)
+		t.Fail()
+	} else if _, ok := engine.(testEngine); !ok {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
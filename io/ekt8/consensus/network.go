@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"xserver/x_http/x_resp"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+	"github.com/EducationEKT/EKT/io/ekt8/util"
+)
+
+/*
+*共识引擎和其他节点之间的通信
+*
+*共识引擎通过Network发送区块和投票、从其他节点获取区块头和投票,不直接调用节点的HTTP接口,
+*在一个进程中运行多个节点或者测试时可以替换成其他的实现
+ */
+type Network interface {
+	// 异步发送区块给peers
+	BroadcastBlock(peers p2p.Peers, block *blockchain.Block)
+	SendVote(peer p2p.Peer, vote *blockchain.BlockVote) error
+	SendVoteResult(peer p2p.Peer, votes blockchain.Votes) error
+	GetBlockHeader(peer p2p.Peer, height int64) (*blockchain.Block, error)
+	GetVotes(peer p2p.Peer, blockHash string) (blockchain.Votes, error)
+}
+
+// 通过节点的HTTP接口通信,见api包
+type HTTPNetwork struct{}
+
+func (HTTPNetwork) BroadcastBlock(peers p2p.Peers, block *blockchain.Block) {
+	data := block.Bytes()
+	for _, peer := range peers {
+		url := fmt.Sprintf(`http://%s:%d/block/api/newBlock`, peer.Address, peer.Port)
+		go util.HttpPost(url, data)
+	}
+}
+
+func (HTTPNetwork) SendVote(peer p2p.Peer, vote *blockchain.BlockVote) error {
+	url := fmt.Sprintf(`http://%s:%d/vote/api/vote`, peer.Address, peer.Port)
+	_, err := util.HttpPost(url, vote.Bytes())
+	return err
+}
+
+func (HTTPNetwork) SendVoteResult(peer p2p.Peer, votes blockchain.Votes) error {
+	url := fmt.Sprintf(`http://%s:%d/vote/api/voteResult`, peer.Address, peer.Port)
+	_, err := util.HttpPost(url, votes.Bytes())
+	return err
+}
+
+func (network HTTPNetwork) GetBlockHeader(peer p2p.Peer, height int64) (*blockchain.Block, error) {
+	url := fmt.Sprintf(`http://%s:%d/block/api/blockByHeight?height=%d`, peer.Address, peer.Port, height)
+	var block blockchain.Block
+	if err := network.getResult(url, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (network HTTPNetwork) GetVotes(peer p2p.Peer, blockHash string) (blockchain.Votes, error) {
+	url := fmt.Sprintf(`http://%s:%d/vote/api/getVotes?hash=%s`, peer.Address, peer.Port, blockHash)
+	var votes blockchain.Votes
+	if err := network.getResult(url, &votes); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// 请求url并且把返回的result解析到v
+func (HTTPNetwork) getResult(url string, v interface{}) error {
+	body, err := util.HttpGet(url)
+	if err != nil {
+		return err
+	}
+	var resp x_resp.XRespBody
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Status != 0 {
+		return errors.New(resp.Msg)
+	}
+	data, err := json.Marshal(resp.Result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -19,6 +19,7 @@ type Account struct {
 	Amount        int64            `json:"amount"`
 	Nonce         int64            `json:"nonce"`
 	Balances      map[string]int64 `json:"balances"`
+	Votes         map[string]int64 `json:"votes,omitempty"` // 候选节点的AccountAddress -> 投票锁定的EKT
 }
 
 func CreateAccount(address string, Amount int64) Account {
@@ -61,16 +62,16 @@ func (account Account) GetAmount() int64 {
 	return account.Amount
 }
 
-func (account Account) AddAmount(amount int64) {
+func (account *Account) AddAmount(amount int64) {
 	account.Amount += amount
 }
 
-func (account Account) ReduceAmount(amount int64) {
+func (account *Account) ReduceAmount(amount int64) {
 	account.Amount -= amount
 	account.Nonce++
 }
 
-func (account Account) AlterPublicKey(newPublicKey []byte) {
+func (account *Account) AlterPublicKey(newPublicKey []byte) {
 	account.HexPublickKey = hex.EncodeToString(newPublicKey)
 	account.Nonce++
 }
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+// 选举交易的action
+const (
+	RegisterCandidate = "register" // 注册成为候选节点
+	VoteCandidate     = "vote"     // 给候选节点投票,投票的EKT被锁定
+	WithdrawVote      = "withdraw" // 撤回投票,锁定的EKT返回账户
+	ScheduleChange    = "schedule" // 治理账户安排出块节点的变更
+)
+
+// 出块节点变更的类型
+const (
+	AddValidator     = "add"
+	RemoveValidator  = "remove"
+	ReplaceValidator = "replace"
+)
+
+// 选举交易的To,这个地址不对应任何账户
+var ElectionAddress = hex.EncodeToString(crypto.Sha3_256([]byte("DPoS election")))
+
+// 候选节点和得到的投票,候选节点由AccountAddress区分
+type Candidate struct {
+	Peer  p2p.Peer `json:"peer"`
+	Votes int64    `json:"votes"`
+}
+
+/*
+*出块节点的变更,在Height之后的第一个轮次开始时生效
+*
+*add增加Peer,remove删除Peer,replace用Peer替换Replaced
+ */
+type ValidatorChange struct {
+	Height   int64     `json:"height"`
+	Type     string    `json:"type"`
+	Peer     p2p.Peer  `json:"peer"`
+	Replaced *p2p.Peer `json:"replaced,omitempty"`
+}
+
+// 选举交易的Data
+type ElectionAction struct {
+	Action    string           `json:"action"`
+	Peer      *p2p.Peer        `json:"peer,omitempty"`
+	Candidate string           `json:"candidate,omitempty"`
+	Change    *ValidatorChange `json:"change,omitempty"`
+}
+
+func (tx *Transaction) IsElection() bool {
+	return tx.To == ElectionAddress && tx.TokenAddress == ""
+}
+
+/*
+*解析选举交易的Data
+*
+*注册时peer的AccountAddress必须是交易的From,投票和撤回时Amount是投票的数量
+ */
+func (tx *Transaction) ElectionAction() (*ElectionAction, error) {
+	if !tx.IsElection() {
+		return nil, errors.New("Not an election transaction")
+	}
+	var action ElectionAction
+	if err := json.Unmarshal([]byte(tx.Data), &action); err != nil {
+		return nil, err
+	}
+	switch action.Action {
+	case RegisterCandidate:
+		if action.Peer == nil || action.Peer.PeerId == "" || action.Peer.AccountAddress != tx.From || tx.Amount != 0 {
+			return nil, errors.New("Invalid candidate")
+		}
+	case VoteCandidate, WithdrawVote:
+		if _, err := hex.DecodeString(action.Candidate); err != nil || action.Candidate == "" || tx.Amount <= 0 {
+			return nil, errors.New("Invalid vote")
+		}
+	case ScheduleChange:
+		if action.Change == nil || action.Change.Validate() != nil || tx.Amount != 0 {
+			return nil, errors.New("Invalid validator change")
+		}
+	default:
+		return nil, errors.New("Invalid action")
+	}
+	return &action, nil
+}
+
+func (change ValidatorChange) Validate() error {
+	if change.Height <= 0 || change.Peer.PeerId == "" {
+		return errors.New("Invalid validator change")
+	}
+	switch change.Type {
+	case AddValidator, RemoveValidator:
+	case ReplaceValidator:
+		if change.Replaced == nil || change.Replaced.Equal(change.Peer) {
+			return errors.New("Invalid validator change")
+		}
+	default:
+		return errors.New("Invalid validator change")
+	}
+	return nil
+}
+
+/*
+*把变更应用到peers上,返回新的节点列表,不修改peers
+*
+*变更可以重复应用: 增加已经存在的节点、删除不存在的节点、替换不存在的节点都不会修改节点列表
+ */
+func (change ValidatorChange) Apply(peers []p2p.Peer) []p2p.Peer {
+	index := func(peer p2p.Peer) int {
+		for i, p := range peers {
+			if p.Equal(peer) {
+				return i
+			}
+		}
+		return -1
+	}
+	result := make([]p2p.Peer, 0, len(peers)+1)
+	switch change.Type {
+	case AddValidator:
+		result = append(result, peers...)
+		if index(change.Peer) == -1 {
+			result = append(result, change.Peer)
+		}
+	case RemoveValidator:
+		for _, peer := range peers {
+			if !peer.Equal(change.Peer) {
+				result = append(result, peer)
+			}
+		}
+	case ReplaceValidator:
+		result = append(result, peers...)
+		if i := index(*change.Replaced); i != -1 && index(change.Peer) == -1 {
+			result[i] = change.Peer
+		}
+	default:
+		result = append(result, peers...)
+	}
+	return result
+}
@@ -2,13 +2,19 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
+
 	"github.com/EducationEKT/EKT/io/ekt8/crypto"
 )
 
+// token的精度最多18位
+const MaxTokenDecimals = 18
+
 type Token struct {
 	Name     string `json:"name"`
 	Total    int64  `json:"total"`
 	Decimals int64  `json:"decimals"`
+	Issuer   string `json:"issuer"`
 }
 
 func (token Token) Address() []byte {
@@ -18,3 +24,15 @@ func (token Token) Address() []byte {
 	}
 	return crypto.Sha3_256(v)
 }
+
+func (token Token) Bytes() []byte {
+	data, _ := json.Marshal(token)
+	return data
+}
+
+func (token Token) Validate() error {
+	if token.Name == "" || token.Total <= 0 || token.Decimals <= 0 || token.Decimals > MaxTokenDecimals {
+		return errors.New("Invalid token")
+	}
+	return nil
+}
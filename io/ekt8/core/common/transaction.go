@@ -3,6 +3,7 @@ package common
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -39,8 +40,8 @@ func NewTransactionResult(tx *Transaction, fee int64, success bool, failMessage
 	}
 }
 
-func GetTransaction(txId []byte) *Transaction {
-	txData, err := db.GetDBInst().Get(txId)
+func GetTransaction(store db.KVStore, txId []byte) *Transaction {
+	txData, err := store.Get(txId)
 	if err != nil {
 		return nil
 	}
@@ -87,6 +88,27 @@ func (tx *Transaction) String() string {
 		tx.From, tx.To, tx.TimeStamp, tx.Amount, tx.Nonce, tx.Data, tx.TokenAddress)
 }
 
+// 发行token的交易To和TokenAddress都为空,Data是Token的json
+func (tx *Transaction) IsIssueToken() bool {
+	return tx.To == "" && tx.TokenAddress == ""
+}
+
+// 发行token的交易发行的token,发行人是交易的From
+func (tx *Transaction) IssuedToken() (*Token, error) {
+	if !tx.IsIssueToken() {
+		return nil, errors.New("Not a token issuance")
+	}
+	var token Token
+	if err := json.Unmarshal([]byte(tx.Data), &token); err != nil {
+		return nil, err
+	}
+	token.Issuer = tx.From
+	if err := token.Validate(); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
 func (tx Transaction) Bytes() []byte {
 	data, _ := json.Marshal(tx)
 	return data
@@ -2,6 +2,7 @@ package common
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 )
 
@@ -11,6 +12,19 @@ func (bytes HexBytes) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf(`"%s"`, hex.EncodeToString(bytes))), nil
 }
 
+func (bytes *HexBytes) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	value, err := hex.DecodeString(str)
+	if err != nil {
+		return err
+	}
+	*bytes = value
+	return nil
+}
+
 type Object interface{}
 
 type CoinType int
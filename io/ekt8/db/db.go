@@ -1,6 +1,6 @@
 package db
 
-var EktDB *LevelDB
+var EktDB KVStore
 
 func InitEKTDB(filePath string) error {
 	db, err := NewLevelDB(filePath)
@@ -11,6 +11,11 @@ func InitEKTDB(filePath string) error {
 	return err
 }
 
-func GetDBInst() *LevelDB {
+// 节点使用的数据库,测试中可以替换成MemoryDB
+func SetDBInst(store KVStore) {
+	EktDB = store
+}
+
+func GetDBInst() KVStore {
 	return EktDB
 }
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"encoding/binary"
+)
+
+/*
+*除了按Hash保存的数据(树节点、Value、区块、区块体和交易)之外,所有的key都属于一个命名空间
+*
+*key的格式是: 命名空间 + '/' + 第一部分 + '/' + 第二部分...,每一部分都是原始的字节
+*高度使用8个字节的大端序,同一条链的区块按照高度的顺序遍历
+ */
+type Namespace string
+
+const (
+	MetaNamespace       Namespace = "meta"       // 数据库自身的信息,比如schema版本
+	HeadNamespace       Namespace = "head"       // chainId -> 当前区块
+	HeightNamespace     Namespace = "height"     // chainId, 高度 -> 区块
+	VotesNamespace      Namespace = "votes"      // 区块Hash -> 投票结果
+	BlockIndexNamespace Namespace = "blockIndex" // chainId, 区块Hash -> 区块的高度
+	TxIndexNamespace    Namespace = "txIndex"    // chainId, 交易id -> 交易所在区块的高度、Hash和位置
+	SnapshotNamespace   Namespace = "snapshot"   // chainId -> 导入的快照的高度
+	MissedSlotNamespace Namespace = "missedSlot" // chainId, 高度 -> 这个区块之前错过出块的节点
+	StateSyncNamespace  Namespace = "stateSync"  // root, hash -> 还没有同步的节点类型
+	NodeNamespace       Namespace = "node"       // 节点自己的信息,比如私钥
+	ChainsNamespace     Namespace = "chains"     // 节点上的所有链
+	CacheNamespace      Namespace = "cache"
+)
+
+const keySeparator = '/'
+
+func (ns Namespace) Key(parts ...[]byte) []byte {
+	size := len(ns)
+	for _, part := range parts {
+		size += len(part) + 1
+	}
+	key := make([]byte, 0, size)
+	key = append(key, ns...)
+	for _, part := range parts {
+		key = append(append(key, keySeparator), part...)
+	}
+	return key
+}
+
+// 遍历parts下所有key时使用的前缀
+func (ns Namespace) Prefix(parts ...[]byte) []byte {
+	return append(ns.Key(parts...), keySeparator)
+}
+
+func HeightBytes(height int64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(height))
+	return data
+}
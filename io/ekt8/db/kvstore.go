@@ -0,0 +1,43 @@
+package db
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// key不存在时Get返回的错误,所有的实现都返回这个错误
+var ErrNotFound = leveldb.ErrNotFound
+
+/*
+*KVStore是存储的抽象,链、树和共识都通过这个接口读写数据
+*
+*LevelDB是节点使用的实现,MemoryDB是线程安全的内存实现,主要用于测试和在一个进程中运行多个节点
+ */
+type KVStore interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	Has(key []byte) (bool, error)
+	// Batch只能写入创建它的KVStore
+	NewBatch() Batch
+	WriteBatch(batch Batch) error
+	// 按照key的字节序遍历所有以prefix开头的key,遍历的是创建时的快照
+	NewIterator(prefix []byte) Iterator
+	// 和NewIterator相同,但是从第一个不小于start的key开始,start需要以prefix开头
+	NewIteratorFrom(prefix, start []byte) Iterator
+	Close() error
+}
+
+type Batch interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+	Len() int
+	Reset()
+}
+
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
+}
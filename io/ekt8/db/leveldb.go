@@ -2,6 +2,7 @@ package db
 
 import (
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type LevelDB struct {
@@ -30,3 +31,34 @@ func (levelDB LevelDB) Get(key []byte) ([]byte, error) {
 func (levelDB LevelDB) Delete(key []byte) error {
 	return levelDB.DB.Delete(key, nil)
 }
+
+func (levelDB LevelDB) Has(key []byte) (bool, error) {
+	return levelDB.DB.Has(key, nil)
+}
+
+func (levelDB LevelDB) NewBatch() Batch {
+	return new(leveldb.Batch)
+}
+
+func (levelDB LevelDB) WriteBatch(batch Batch) error {
+	levelBatch, ok := batch.(*leveldb.Batch)
+	if !ok {
+		return InvalidBatch
+	}
+	return levelDB.DB.Write(levelBatch, nil)
+}
+
+// 遍历所有以prefix开头的key,prefix为nil时遍历整个数据库
+func (levelDB LevelDB) NewIterator(prefix []byte) Iterator {
+	return levelDB.DB.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (levelDB LevelDB) NewIteratorFrom(prefix, start []byte) Iterator {
+	slice := util.BytesPrefix(prefix)
+	slice.Start = start
+	return levelDB.DB.NewIterator(slice, nil)
+}
+
+func (levelDB LevelDB) Close() error {
+	return levelDB.DB.Close()
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var InvalidBatch = errors.New("Invalid batch")
+
+type MemoryDB struct {
+	locker sync.RWMutex
+	data   map[string][]byte
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{data: make(map[string][]byte)}
+}
+
+func (memDB *MemoryDB) Get(key []byte) ([]byte, error) {
+	memDB.locker.RLock()
+	defer memDB.locker.RUnlock()
+	value, exist := memDB.data[string(key)]
+	if !exist {
+		return nil, ErrNotFound
+	}
+	return copyBytes(value), nil
+}
+
+func (memDB *MemoryDB) Set(key, value []byte) error {
+	memDB.locker.Lock()
+	defer memDB.locker.Unlock()
+	memDB.data[string(key)] = copyBytes(value)
+	return nil
+}
+
+func (memDB *MemoryDB) Delete(key []byte) error {
+	memDB.locker.Lock()
+	defer memDB.locker.Unlock()
+	delete(memDB.data, string(key))
+	return nil
+}
+
+func (memDB *MemoryDB) Has(key []byte) (bool, error) {
+	memDB.locker.RLock()
+	defer memDB.locker.RUnlock()
+	_, exist := memDB.data[string(key)]
+	return exist, nil
+}
+
+func (memDB *MemoryDB) NewBatch() Batch {
+	return &memoryBatch{}
+}
+
+func (memDB *MemoryDB) WriteBatch(batch Batch) error {
+	memBatch, ok := batch.(*memoryBatch)
+	if !ok {
+		return InvalidBatch
+	}
+	memDB.locker.Lock()
+	defer memDB.locker.Unlock()
+	for _, op := range memBatch.ops {
+		if op.delete {
+			delete(memDB.data, op.key)
+		} else {
+			memDB.data[op.key] = op.value
+		}
+	}
+	return nil
+}
+
+func (memDB *MemoryDB) NewIterator(prefix []byte) Iterator {
+	return memDB.NewIteratorFrom(prefix, prefix)
+}
+
+func (memDB *MemoryDB) NewIteratorFrom(prefix, start []byte) Iterator {
+	memDB.locker.RLock()
+	defer memDB.locker.RUnlock()
+	iter := &memoryIterator{index: -1}
+	for key := range memDB.data {
+		if strings.HasPrefix(key, string(prefix)) && key >= string(start) {
+			iter.keys = append(iter.keys, key)
+		}
+	}
+	sort.Strings(iter.keys)
+	iter.values = make([][]byte, len(iter.keys))
+	for i, key := range iter.keys {
+		iter.values[i] = memDB.data[key]
+	}
+	return iter
+}
+
+func (memDB *MemoryDB) Close() error {
+	return nil
+}
+
+type memoryOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+type memoryBatch struct {
+	ops []memoryOp
+}
+
+func (batch *memoryBatch) Put(key, value []byte) {
+	batch.ops = append(batch.ops, memoryOp{key: string(key), value: copyBytes(value)})
+}
+
+func (batch *memoryBatch) Delete(key []byte) {
+	batch.ops = append(batch.ops, memoryOp{key: string(key), delete: true})
+}
+
+func (batch *memoryBatch) Len() int {
+	return len(batch.ops)
+}
+
+func (batch *memoryBatch) Reset() {
+	batch.ops = batch.ops[:0]
+}
+
+// 创建时复制了所有的key,之后对数据库的修改不会影响遍历
+type memoryIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func (iter *memoryIterator) Next() bool {
+	if iter.index < len(iter.keys) {
+		iter.index++
+	}
+	return iter.index < len(iter.keys)
+}
+
+func (iter *memoryIterator) Key() []byte {
+	if iter.index < 0 || iter.index >= len(iter.keys) {
+		return nil
+	}
+	return []byte(iter.keys[iter.index])
+}
+
+func (iter *memoryIterator) Value() []byte {
+	if iter.index < 0 || iter.index >= len(iter.keys) {
+		return nil
+	}
+	return copyBytes(iter.values[iter.index])
+}
+
+func (iter *memoryIterator) Release() {
+	iter.keys, iter.values = nil, nil
+}
+
+func (iter *memoryIterator) Error() error {
+	return nil
+}
+
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	result := make([]byte, len(data))
+	copy(result, data)
+	return result
+}
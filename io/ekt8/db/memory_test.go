@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemoryDB(t *testing.T) {
+	var store KVStore = NewMemoryDB()
+	store.Set([]byte("a1"), []byte("value1"))
+	store.Set([]byte("b1"), []byte("value3"))
+	batch := store.NewBatch()
+	batch.Put([]byte("a2"), []byte("value2"))
+	batch.Delete([]byte("b1"))
+	if exist, _ := store.Has([]byte("a2")); exist {
+		fmt.Println("batch is written before WriteBatch")
+		t.Fail()
+	}
+	if err := store.WriteBatch(batch); err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+	if _, err := store.Get([]byte("b1")); err != ErrNotFound {
+		fmt.Println("deleted key still exists")
+		t.Fail()
+	}
+	iter := store.NewIterator([]byte("a"))
+	// 遍历时修改数据库不会影响遍历的结果
+	store.Set([]byte("a3"), []byte("value4"))
+	values := make([]string, 0)
+	for iter.Next() {
+		values = append(values, string(iter.Key())+"="+string(iter.Value()))
+	}
+	iter.Release()
+	if len(values) != 2 || values[0] != "a1=value1" || values[1] != "a2=value2" {
+		fmt.Println(values)
+		t.Fail()
+	}
+	iter = store.NewIteratorFrom([]byte("a"), []byte("a2"))
+	values = values[:0]
+	for iter.Next() {
+		values = append(values, string(iter.Key()))
+	}
+	iter.Release()
+	if len(values) != 2 || values[0] != "a2" || values[1] != "a3" {
+		fmt.Println("iterate from a2", values)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
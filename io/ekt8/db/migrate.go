@@ -0,0 +1,261 @@
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// 当前代码使用的数据库schema版本,修改key或者value的格式时增加版本并添加对应的Migration
+const SchemaVersion = 1
+
+// 迁移时每修改多少个key写一次数据库
+const migrationBatchSize = 10000
+
+var SchemaVersionKey = MetaNamespace.Key([]byte("schemaVersion"))
+
+var NewerSchema = errors.New("Database schema is newer than this node")
+
+/*
+*Migration把数据库从Version-1升级到Version
+*
+*每个Migration必须可以重复执行,中途退出之后下次启动会从头再执行一次
+ */
+type Migration struct {
+	Version int
+	Name    string
+	Migrate func(store KVStore) error
+}
+
+// 按版本从小到大排列
+var migrations = []Migration{
+	{Version: 1, Name: "namespaced keys", Migrate: migrateNamespacedKeys},
+}
+
+// 没有保存版本的数据库是版本0
+func GetSchemaVersion(store KVStore) (int, error) {
+	data, err := store.Get(SchemaVersionKey)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+/*
+*把数据库升级到SchemaVersion,返回执行的Migration数量
+*
+*空的数据库直接写入当前版本,数据库的版本比代码新时返回NewerSchema
+ */
+func Migrate(store KVStore) (int, error) {
+	version, err := GetSchemaVersion(store)
+	if err != nil {
+		return 0, err
+	}
+	if version > SchemaVersion {
+		return 0, NewerSchema
+	}
+	if version == 0 && IsEmpty(store) {
+		return 0, setSchemaVersion(store, SchemaVersion)
+	}
+	applied := 0
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+		if err = migration.Migrate(store); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed, %v", migration.Version, migration.Name, err)
+		}
+		if err = setSchemaVersion(store, migration.Version); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+func setSchemaVersion(store KVStore, version int) error {
+	return store.Set(SchemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+func IsEmpty(store KVStore) bool {
+	iter := store.NewIterator(nil)
+	defer iter.Release()
+	return !iter.Next()
+}
+
+// 把以prefix开头的key改成rename返回的key,rename返回nil时不修改这个key
+func renameKeys(store KVStore, prefix []byte, rename func(key, value []byte) ([]byte, error)) error {
+	iter := store.NewIterator(prefix)
+	defer iter.Release()
+	batch := store.NewBatch()
+	for iter.Next() {
+		newKey, err := rename(iter.Key()[len(prefix):], iter.Value())
+		if err != nil {
+			return fmt.Errorf("key %q: %v", iter.Key(), err)
+		}
+		if newKey == nil {
+			continue
+		}
+		batch.Put(newKey, iter.Value())
+		batch.Delete(iter.Key())
+		if batch.Len() >= migrationBatchSize {
+			if err = store.WriteBatch(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return store.WriteBatch(batch)
+}
+
+func renameKey(store KVStore, oldKey, newKey []byte) error {
+	return renameKeys(store, oldKey, func(rest, value []byte) ([]byte, error) {
+		if len(rest) != 0 {
+			return nil, nil
+		}
+		return newKey, nil
+	})
+}
+
+/*
+*版本1: 把旧版本中格式不统一的key迁移到命名空间中
+*
+*只有旧版本中存在的key需要迁移,之后添加的数据从一开始就使用命名空间
+*旧版本没有区块Hash和交易的索引,迁移之后根据高度索引中的区块建立索引
+*
+*旧版本的高度索引使用了fmt.Sprint,key是"GetBlockByHeight: _%s_%d"后面直接拼接chainId的hex和十进制的高度,
+*高度需要从区块中读取才能确定chainId的结束位置
+ */
+func migrateNamespacedKeys(store KVStore) error {
+	err := renameKeys(store, []byte("CurrentBlockKey"), func(chainId, value []byte) ([]byte, error) {
+		return HeadNamespace.Key(chainId), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = renameKeys(store, []byte("GetBlockByHeight: _%s_%d"), func(rest, value []byte) ([]byte, error) {
+		var block struct {
+			Height int64 `json:"height"`
+		}
+		if err := json.Unmarshal(value, &block); err != nil {
+			return nil, err
+		}
+		height := strconv.FormatInt(block.Height, 10)
+		if !bytes.HasSuffix(rest, []byte(height)) {
+			return nil, errors.New("height mismatch")
+		}
+		chainId, err := hex.DecodeString(string(rest[:len(rest)-len(height)]))
+		if err != nil {
+			return nil, err
+		}
+		return HeightNamespace.Key(chainId, HeightBytes(block.Height)), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = renameKeys(store, []byte("block_votes:"), func(rest, value []byte) ([]byte, error) {
+		hash, err := hex.DecodeString(string(rest))
+		if err != nil {
+			return nil, err
+		}
+		return VotesNamespace.Key(hash), nil
+	})
+	if err != nil {
+		return err
+	}
+	renames := [][2][]byte{
+		{[]byte("peerIdInfo"), NodeNamespace.Key([]byte("privateKey"))},
+		{[]byte("BlockchainManagerDBKey"), ChainsNamespace.Key()},
+		{[]byte("MainBlockchainCurrentBlockKey"), CacheNamespace.Key([]byte("currentBlock"))},
+		{[]byte("inited"), CacheNamespace.Key([]byte("inited"))},
+	}
+	for _, rename := range renames {
+		if err = renameKey(store, rename[0], rename[1]); err != nil {
+			return err
+		}
+	}
+	return indexBlocks(store)
+}
+
+/*
+*根据高度索引建立区块Hash到高度的索引和交易的位置索引
+*
+*交易的位置是json格式的{"height", "blockHash", "index"},和blockchain.TxLocation一致
+ */
+func indexBlocks(store KVStore) error {
+	prefix := HeightNamespace.Prefix()
+	iter := store.NewIterator(prefix)
+	defer iter.Release()
+	batch := store.NewBatch()
+	for iter.Next() {
+		key := iter.Key()[len(prefix):]
+		if len(key) < 9 {
+			continue
+		}
+		chainId := key[:len(key)-9]
+		var block struct {
+			Height      int64  `json:"height"`
+			CurrentHash string `json:"currentHash"`
+			Body        string `json:"body"`
+		}
+		if err := json.Unmarshal(iter.Value(), &block); err != nil {
+			return fmt.Errorf("key %q: %v", iter.Key(), err)
+		}
+		hash, err := hex.DecodeString(block.CurrentHash)
+		if err != nil || len(hash) == 0 {
+			continue
+		}
+		batch.Put(BlockIndexNamespace.Key(chainId, hash), []byte(strconv.FormatInt(block.Height, 10)))
+		if body, err := hex.DecodeString(block.Body); err == nil && len(body) > 0 {
+			if err = indexTxLocations(store, batch, chainId, block.Height, block.CurrentHash, body); err != nil {
+				return err
+			}
+		}
+		if batch.Len() >= migrationBatchSize {
+			if err = store.WriteBatch(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return store.WriteBatch(batch)
+}
+
+func indexTxLocations(store KVStore, batch Batch, chainId []byte, height int64, blockHash string, bodyHash []byte) error {
+	data, err := store.Get(bodyHash)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var body struct {
+		TxResults []struct {
+			TxId string `json:"txId"`
+		} `json:"txResults"`
+	}
+	if err = json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	for i, txResult := range body.TxResults {
+		txId, err := hex.DecodeString(txResult.TxId)
+		if err != nil {
+			return err
+		}
+		location := fmt.Sprintf(`{"height":%d,"blockHash":"%s","index":%d}`, height, blockHash, i)
+		batch.Put(TxIndexNamespace.Key(chainId, txId), []byte(location))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	store := NewMemoryDB()
+	chainId := bytes.Repeat([]byte{1}, 32)
+	hash := bytes.Repeat([]byte{2}, 32)
+	block := []byte(`{"height":12}`)
+	// 旧版本的key
+	store.Set(append([]byte("CurrentBlockKey"), chainId...), block)
+	store.Set([]byte("GetBlockByHeight: _%s_%d"+hex.EncodeToString(chainId)+"12"), block)
+	store.Set([]byte("block_votes:"+hex.EncodeToString(hash)), []byte("votes"))
+	store.Set([]byte("peerIdInfo"), []byte("priv"))
+	store.Set(hash, []byte("node"))
+
+	applied, err := Migrate(store)
+	if err != nil || applied != len(migrations) {
+		fmt.Println(applied, err)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		string(HeadNamespace.Key(chainId)):                    string(block),
+		string(HeightNamespace.Key(chainId, HeightBytes(12))): string(block),
+		string(VotesNamespace.Key(hash)):                      "votes",
+		string(NodeNamespace.Key([]byte("privateKey"))):       "priv",
+		string(hash): "node",
+	}
+	for key, value := range expected {
+		if data, err := store.Get([]byte(key)); err != nil || string(data) != value {
+			fmt.Printf("key %q is not migrated \n", key)
+			t.Fail()
+		}
+	}
+	if exist, _ := store.Has([]byte("peerIdInfo")); exist {
+		t.Fail()
+	}
+	if version, _ := GetSchemaVersion(store); version != SchemaVersion {
+		t.Fail()
+	}
+	if applied, err = Migrate(store); applied != 0 || err != nil {
+		t.Fail()
+	}
+
+	// 新的数据库直接使用当前版本,比代码新的数据库不能打开
+	empty := NewMemoryDB()
+	if applied, err = Migrate(empty); applied != 0 || err != nil {
+		t.Fail()
+	}
+	if version, _ := GetSchemaVersion(empty); version != SchemaVersion {
+		t.Fail()
+	}
+	setSchemaVersion(empty, SchemaVersion+1)
+	if _, err = Migrate(empty); err != NewerSchema {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
+
+func TestMigrateBlockIndex(t *testing.T) {
+	store := NewMemoryDB()
+	chainId := bytes.Repeat([]byte{1}, 32)
+	hash := bytes.Repeat([]byte{2}, 32)
+	bodyHash := bytes.Repeat([]byte{3}, 32)
+	txId := bytes.Repeat([]byte{4}, 32)
+	// 旧版本的高度索引,没有区块Hash和交易的索引
+	block := fmt.Sprintf(`{"height":12,"currentHash":"%s","body":"%s"}`, hex.EncodeToString(hash), hex.EncodeToString(bodyHash))
+	store.Set([]byte("GetBlockByHeight: _%s_%d"+hex.EncodeToString(chainId)+"12"), []byte(block))
+	store.Set(bodyHash, []byte(`{"height":12,"txResults":[{"txId":"00"},{"txId":"`+hex.EncodeToString(txId)+`"}]}`))
+
+	if applied, err := Migrate(store); err != nil || applied != 1 {
+		fmt.Println(applied, err)
+		t.FailNow()
+	}
+	if data, err := store.Get(BlockIndexNamespace.Key(chainId, hash)); err != nil || string(data) != "12" {
+		fmt.Println("block index not built", err)
+		t.Fail()
+	}
+	location := fmt.Sprintf(`{"height":12,"blockHash":"%s","index":1}`, hex.EncodeToString(hash))
+	if data, err := store.Get(TxIndexNamespace.Key(chainId, txId)); err != nil || string(data) != location {
+		fmt.Println("tx location not built", string(data), err)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
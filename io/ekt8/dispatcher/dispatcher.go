@@ -4,12 +4,18 @@ import (
 	"encoding/hex"
 	"errors"
 
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
 	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
 	"github.com/EducationEKT/EKT/io/ekt8/context_log"
 	"github.com/EducationEKT/EKT/io/ekt8/core/common"
 )
 
 func NewTransaction(log *context_log.ContextLog, transaction *common.Transaction) error {
+	if transaction.IsIssueToken() {
+		if _, err := transaction.IssuedToken(); err != nil {
+			return err
+		}
+	}
 	// 主币的tokenAddress为空
 	if transaction.TokenAddress != "" {
 		log.Log("tokenAdddress", transaction.TokenAddress)
@@ -20,9 +26,16 @@ func NewTransaction(log *context_log.ContextLog, transaction *common.Transaction
 		currentBlock := blockchain_manager.GetMainChain().GetLastBlock()
 		var token common.Token
 		err = currentBlock.TokenTree.GetInterfaceValue(tokenAddress, &token)
-		if err != nil || token.Name == "" || token.Decimals <= 0 || token.Total <= 0 {
+		if err != nil {
 			return err
 		}
+		if err = token.Validate(); err != nil {
+			return err
+		}
+	}
+	// 保存选举状态的地址不能接收转账
+	if to, err := hex.DecodeString(transaction.To); err == nil && blockchain.IsReservedAddress(to) {
+		return errors.New("reserved address")
 	}
 	log.Log("transfer EKT", true)
 	if !transaction.Validate() {
@@ -55,8 +55,8 @@ func (event Event) EventId() []byte {
 	return crypto.Sha3_256(data)
 }
 
-func GetEvent(eventId []byte) *Event {
-	data, err := db.GetDBInst().Get(eventId)
+func GetEvent(store db.KVStore, eventId []byte) *Event {
+	data, err := store.Get(eventId)
 	if err != nil {
 		return nil
 	}
@@ -8,6 +8,7 @@ const (
 
 type ConsensusType int
 
+// 校验和写入区块的接口在consensus.Engine中声明,i_consensus不能依赖blockchain
 type Consensus interface {
 	//接口中没有声明，但是在Consensus的所有的实现struct中都必须拥有blockchain结构体
 	Run()
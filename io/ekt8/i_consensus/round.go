@@ -3,7 +3,6 @@ package i_consensus
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
 
 	"github.com/EducationEKT/EKT/io/ekt8/conf"
 	"github.com/EducationEKT/EKT/io/ekt8/log"
@@ -29,15 +28,34 @@ func (round1 *Round) Equal(round2 *Round) bool {
 	return true
 }
 
+// 两个轮次的节点是否相同,不比较顺序
+func (round1 *Round) SamePeers(round2 *Round) bool {
+	if len(round1.Peers) != len(round2.Peers) {
+		return false
+	}
+	for _, peer := range round1.Peers {
+		found := false
+		for _, peer_ := range round2.Peers {
+			if peer.Equal(peer_) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func (round *Round) IndexPlus(CurrentHash []byte) *Round {
 	if round.CurrentIndex == len(round.Peers)-1 {
 		Random := util.BytesToInt(CurrentHash[22:])
 		round_ := &Round{
 			CurrentIndex: 0,
-			Peers:        round.Peers,
+			Peers:        Shuffle(round.Peers, CurrentHash),
 			Random:       Random,
 		}
-		sort.Sort(round_)
 		return round_
 	} else {
 		round.CurrentIndex++
@@ -45,9 +63,10 @@ func (round *Round) IndexPlus(CurrentHash []byte) *Round {
 	return round
 }
 
+// 新的一轮,节点的顺序由上一轮最后一个区块的Hash决定,见Shuffle
 func (round *Round) NewRandom(CurrentHash []byte) *Round {
 	round1 := &Round{
-		Peers:        round.Peers,
+		Peers:        Shuffle(round.Peers, CurrentHash),
 		Random:       util.BytesToInt(CurrentHash[22:]),
 		CurrentIndex: round.CurrentIndex,
 	}
@@ -70,7 +89,6 @@ func (round *Round) MyRound(CurrentHash []byte) *Round {
 	_round := round.NewRound()
 	if round.CurrentIndex == round.Len()-1 {
 		_round = round.NewRandom(CurrentHash)
-		sort.Sort(_round)
 	}
 	_round.CurrentIndex = _round.MyIndex()
 	log.GetLogInst().LogDebug("My Round is %s", _round.String())
@@ -83,7 +101,7 @@ func (round *Round) NextRound(CurrentHash []byte) *Round {
 		Random := util.BytesToInt(bytes)
 		round = &Round{
 			CurrentIndex: 0,
-			Peers:        round.Peers,
+			Peers:        Shuffle(round.Peers, CurrentHash),
 			Random:       Random,
 		}
 	} else {
@@ -107,7 +125,6 @@ func (round Round) NextPeerRight(peer p2p.Peer, hash []byte) bool {
 		return false
 	} else {
 		_round := round.NewRandom(hash)
-		sort.Sort(_round)
 		return _round.Peers[0].Equal(peer)
 	}
 }
@@ -125,14 +142,6 @@ func (round Round) Len() int {
 	return len(round.Peers)
 }
 
-func (round Round) Swap(i, j int) {
-	round.Peers[i], round.Peers[j] = round.Peers[j], round.Peers[i]
-}
-
-func (round Round) Less(i, j int) bool {
-	return round.Random%(i+j)%2 == 1
-}
-
 func (round Round) String() string {
 	peers, _ := json.Marshal(round.Peers)
 	return fmt.Sprintf(`{"currentIndex": %d, "peers": %s, "random": %d}`, round.CurrentIndex, string(peers), round.Random)
@@ -0,0 +1,41 @@
+package i_consensus
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+/*
+*每一轮出块节点的顺序,任何人都可以用节点列表和上一轮最后一个区块的Hash独立验证,见docs/shuffle.md
+*
+*1. 按照PeerId、Address、Port从小到大排序,PeerId不区分大小写,这样结果和节点原来的顺序无关
+*2. Fisher–Yates洗牌: i从n-1到1,j = uint64(Sha3_256(seed || uint32(i))[0:8]) mod (i+1),交换第i个和第j个节点,整数都是大端编码
+*
+*返回新的slice,不修改peers
+ */
+func Shuffle(peers []p2p.Peer, seed []byte) []p2p.Peer {
+	shuffled := make([]p2p.Peer, len(peers))
+	copy(shuffled, peers)
+	sort.SliceStable(shuffled, func(i, j int) bool {
+		a, b := shuffled[i], shuffled[j]
+		if idA, idB := strings.ToLower(a.PeerId), strings.ToLower(b.PeerId); idA != idB {
+			return idA < idB
+		}
+		if a.Address != b.Address {
+			return a.Address < b.Address
+		}
+		return a.Port < b.Port
+	})
+	for i := len(shuffled) - 1; i > 0; i-- {
+		data := make([]byte, len(seed)+4)
+		copy(data, seed)
+		binary.BigEndian.PutUint32(data[len(seed):], uint32(i))
+		j := int(binary.BigEndian.Uint64(crypto.Sha3_256(data)[:8]) % uint64(i+1))
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled
+}
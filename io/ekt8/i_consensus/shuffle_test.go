@@ -0,0 +1,71 @@
+package i_consensus
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+// 和docs/shuffle.md中的测试向量一致,修改时两边需要同时修改
+var shuffleVectors = []struct {
+	count  int
+	seed   string
+	expect string
+}{
+	{1, "", "00"},
+	{2, "", "00,01"},
+	{3, "", "00,02,01"},
+	{3, "EKT", "02,01,00"},
+	{5, "", "03,04,01,00,02"},
+	{5, "EKT", "02,04,03,00,01"},
+	{21, "", "03,20,08,00,13,18,10,15,01,02,19,14,17,07,09,06,11,12,04,16,05"},
+	{21, "EKT", "02,17,15,19,09,20,14,12,07,01,13,06,05,04,10,03,16,08,11,00,18"},
+}
+
+func peerIds(peers []p2p.Peer) string {
+	ids := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		ids = append(ids, peer.PeerId)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestShuffle(t *testing.T) {
+	for _, vector := range shuffleVectors {
+		peers := make([]p2p.Peer, 0, vector.count)
+		// 倒序输入,结果和输入的顺序无关
+		for i := vector.count - 1; i >= 0; i-- {
+			peers = append(peers, p2p.Peer{PeerId: fmt.Sprintf("%02d", i)})
+		}
+		before := peerIds(peers)
+		seed := crypto.Sha3_256([]byte(vector.seed))
+		if result := peerIds(Shuffle(peers, seed)); result != vector.expect {
+			fmt.Println(vector.count, vector.seed, result)
+			t.Fail()
+		}
+		if peerIds(peers) != before {
+			fmt.Println("input peers are modified")
+			t.Fail()
+		}
+	}
+	fmt.Println("success")
+}
+
+func TestNewRandom(t *testing.T) {
+	peers := []p2p.Peer{{PeerId: "02", Port: 2}, {PeerId: "00", Port: 0}, {PeerId: "01", Port: 1}}
+	round := &Round{Peers: peers, CurrentIndex: 2}
+	hash := crypto.Sha3_256([]byte("EKT"))
+	next := round.NewRandom(hash)
+	if peerIds(next.Peers) != "02,01,00" || peerIds(round.Peers) != "02,00,01" {
+		fmt.Println(next, round)
+		t.Fail()
+	}
+	// 新的一轮第一个出块的是洗牌之后的第一个节点
+	if !round.NextPeerRight(peers[0], hash) || round.NextPeerRight(peers[1], hash) {
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -0,0 +1,71 @@
+package i_consensus
+
+import (
+	"time"
+
+	"github.com/EducationEKT/EKT/io/ekt8/p2p"
+)
+
+/*
+*出块的时间槽,Owner需要在Deadline之前产生区块,超过Deadline之后由下一个时间槽的节点出块
+*
+*Round是在这个时间槽产生的区块使用的轮次,CurrentIndex是Owner的位置
+ */
+type Slot struct {
+	Index    int      `json:"index"` // 上一个区块之后的第几个时间槽,从0开始
+	Owner    p2p.Peer `json:"owner"`
+	Start    int64    `json:"start"`    // 毫秒
+	Deadline int64    `json:"deadline"` // 毫秒
+	Round    *Round   `json:"-"`
+}
+
+/*
+*上一个区块之后的出块顺序
+*
+*第index个时间槽是[LastBlockTime + index*Interval, LastBlockTime + (index+1)*Interval),
+*由当前轮次中CurrentIndex之后的第index+1个节点出块,当前轮次结束之后按照下一轮洗牌之后的顺序循环
+ */
+type Schedule struct {
+	Round         *Round
+	Next          *Round // 当前轮次结束之后的轮次,已经按照上一个区块的Hash洗牌
+	LastBlockTime int64  // 毫秒
+	Interval      int64  // 毫秒
+}
+
+// next是下一轮的节点,seed是上一个区块的Hash
+func NewSchedule(round *Round, next []p2p.Peer, seed []byte, lastBlockTime int64, interval time.Duration) *Schedule {
+	return &Schedule{
+		Round:         round.NewRound(),
+		Next:          (&Round{Peers: next, CurrentIndex: -1}).NewRandom(seed),
+		LastBlockTime: lastBlockTime,
+		Interval:      int64(interval / time.Millisecond),
+	}
+}
+
+// 没有节点时返回的时间槽没有Owner和Round
+func (schedule *Schedule) Slot(index int) Slot {
+	start := schedule.LastBlockTime + int64(index)*schedule.Interval
+	slot := Slot{Index: index, Start: start, Deadline: start + schedule.Interval}
+	if schedule.Next.Len() == 0 {
+		return slot
+	}
+	var round *Round
+	if position := schedule.Round.CurrentIndex + 1 + index; position < schedule.Round.Len() {
+		round = schedule.Round.NewRound()
+		round.CurrentIndex = position
+	} else {
+		round = schedule.Next.NewRound()
+		round.CurrentIndex = (position - schedule.Round.Len()) % round.Len()
+	}
+	slot.Owner, slot.Round = round.Peers[round.CurrentIndex], round
+	return slot
+}
+
+// timestamp所在的时间槽,早于上一个区块的时间属于第0个时间槽
+func (schedule *Schedule) SlotAt(timestamp int64) Slot {
+	index := 0
+	if timestamp > schedule.LastBlockTime && schedule.Interval > 0 {
+		index = int((timestamp - schedule.LastBlockTime) / schedule.Interval)
+	}
+	return schedule.Slot(index)
+}
@@ -0,0 +1,398 @@
+package indexer
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/log"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	// 一次最多查询多少个区块
+	MaxBlockRange = 100
+)
+
+var NotEnabled = errors.New("Indexer is not enabled")
+
+var indexerInst *Indexer
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS blocks (
+		height INTEGER PRIMARY KEY,
+		hash TEXT NOT NULL,
+		previous_hash TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		fee INTEGER NOT NULL,
+		tx_count INTEGER NOT NULL,
+		event_count INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS transactions (
+		tx_id TEXT PRIMARY KEY,
+		height INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		from_address TEXT NOT NULL,
+		to_address TEXT NOT NULL,
+		amount INTEGER NOT NULL,
+		token TEXT NOT NULL,
+		fee INTEGER NOT NULL,
+		nonce INTEGER NOT NULL,
+		timestamp INTEGER NOT NULL,
+		success INTEGER NOT NULL,
+		fail_msg TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS transactions_from ON transactions (from_address, height)`,
+	`CREATE INDEX IF NOT EXISTS transactions_to ON transactions (to_address, height)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		event_id TEXT PRIMARY KEY,
+		height INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		success INTEGER NOT NULL,
+		reason TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS balance_changes (
+		address TEXT NOT NULL,
+		height INTEGER NOT NULL,
+		tx_id TEXT NOT NULL,
+		token TEXT NOT NULL,
+		delta INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS balance_changes_address ON balance_changes (address, height)`,
+}
+
+type BlockRecord struct {
+	Height       int64  `json:"height"`
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previousHash"`
+	Timestamp    int64  `json:"timestamp"`
+	Fee          int64  `json:"fee"`
+	TxCount      int    `json:"txCount"`
+	EventCount   int    `json:"eventCount"`
+}
+
+type TxRecord struct {
+	TxId      string `json:"txId"`
+	Height    int64  `json:"height"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Amount    int64  `json:"amount"`
+	Token     string `json:"tokenAddress"`
+	Fee       int64  `json:"fee"`
+	Nonce     int64  `json:"nonce"`
+	Timestamp int64  `json:"time"`
+	Success   bool   `json:"success"`
+	FailMsg   string `json:"failMsg"`
+}
+
+type BalanceChange struct {
+	Address string `json:"address"`
+	Token   string `json:"tokenAddress"`
+	Delta   int64  `json:"delta"`
+}
+
+/*
+*把写入区块链的区块保存到SQLite中,提供按地址查询交易等区块链数据库不支持的查询
+*
+*索引的最新高度就是blocks表中的最大高度,启动和收到新区块时从这个高度开始补齐到链的当前高度
+ */
+type Indexer struct {
+	DB     *db.Sqlite
+	Chain  *blockchain.BlockChain
+	signal chan struct{}
+}
+
+func NewIndexer(sqlite *db.Sqlite, chain *blockchain.BlockChain) (*Indexer, error) {
+	for _, stmt := range schema {
+		if _, err := sqlite.DB.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &Indexer{DB: sqlite, Chain: chain, signal: make(chan struct{}, 1)}, nil
+}
+
+// 打开path对应的SQLite数据库,并开始索引chain上的区块
+func Init(path string, chain *blockchain.BlockChain) error {
+	sqlite, err := db.NewSqlite(path)
+	if err != nil {
+		return err
+	}
+	indexer, err := NewIndexer(sqlite, chain)
+	if err != nil {
+		return err
+	}
+	indexerInst = indexer
+	go indexer.Run()
+	return nil
+}
+
+// 没有开启索引时返回nil
+func GetInst() *Indexer {
+	return indexerInst
+}
+
+func (indexer *Indexer) Run() {
+	indexer.Chain.Subscribe(func(block *blockchain.Block) {
+		select {
+		case indexer.signal <- struct{}{}:
+		default:
+		}
+	})
+	for {
+		if err := indexer.CatchUp(); err != nil {
+			log.GetLogInst().LogCrit("Index block failed, %v.", err)
+		}
+		<-indexer.signal
+	}
+}
+
+// 索引从上次的高度到链的当前高度之间的区块
+func (indexer *Indexer) CatchUp() error {
+	height, err := indexer.LastHeight()
+	if err != nil {
+		return err
+	}
+	if first := indexer.Chain.FirstHeight(); height < first-1 {
+		height = first - 1
+	}
+	if height, err = indexer.rewind(height); err != nil {
+		return err
+	}
+	for height < indexer.Chain.GetLastHeight() {
+		height++
+		block, err := indexer.Chain.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		if err = indexer.IndexBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*区块链切换分支之后,已经索引的区块可能不在主链上
+*
+*从height向前找到hash和主链一致的区块,删除这个高度之后的所有记录,返回这个高度
+ */
+func (indexer *Indexer) rewind(height int64) (int64, error) {
+	rewound := height
+	for ; rewound > 0 && rewound > height-blockchain.MaxReorgDepth; rewound-- {
+		var hash string
+		err := indexer.DB.DB.QueryRow(`SELECT hash FROM blocks WHERE height = ?`, rewound).Scan(&hash)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return height, err
+		}
+		if block, err := indexer.Chain.GetBlockByHeight(rewound); err == nil && hex.EncodeToString(block.Hash()) == hash {
+			break
+		}
+	}
+	if rewound == height {
+		return height, nil
+	}
+	log.GetLogInst().LogInfo("Indexed blocks after height %d are not on the main chain, reindexing.", rewound)
+	sqlTx, err := indexer.DB.DB.Begin()
+	if err != nil {
+		return height, err
+	}
+	defer sqlTx.Rollback()
+	for _, table := range []string{"blocks", "transactions", "events", "balance_changes"} {
+		if _, err = sqlTx.Exec(`DELETE FROM `+table+` WHERE height > ?`, rewound); err != nil {
+			return height, err
+		}
+	}
+	return rewound, sqlTx.Commit()
+}
+
+func (indexer *Indexer) LastHeight() (int64, error) {
+	var height sql.NullInt64
+	err := indexer.DB.DB.QueryRow(`SELECT MAX(height) FROM blocks`).Scan(&height)
+	return height.Int64, err
+}
+
+// 一个区块的所有数据在同一个事务中写入
+func (indexer *Indexer) IndexBlock(block *blockchain.Block) error {
+	body, err := indexer.blockBody(block)
+	if err != nil {
+		return err
+	}
+	sqlTx, err := indexer.DB.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+	if _, err = sqlTx.Exec(`DELETE FROM balance_changes WHERE height = ?`, block.Height); err != nil {
+		return err
+	}
+	_, err = sqlTx.Exec(`INSERT OR REPLACE INTO blocks VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		block.Height, hex.EncodeToString(block.CurrentHash), hex.EncodeToString(block.PreviousHash),
+		block.Timestamp, block.TotalFee, len(body.TxResults), len(body.EventResults))
+	if err != nil {
+		return err
+	}
+	for i, txResult := range body.TxResults {
+		tx, err := indexer.transaction(txResult.TxId)
+		if err != nil {
+			return err
+		}
+		_, err = sqlTx.Exec(`INSERT OR REPLACE INTO transactions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			txResult.TxId, block.Height, i, tx.From, tx.To, tx.Amount, tx.TokenAddress,
+			txResult.Fee, tx.Nonce, tx.TimeStamp, txResult.Success, txResult.FailMsg)
+		if err != nil {
+			return err
+		}
+		for _, change := range BalanceChanges(tx, txResult) {
+			_, err = sqlTx.Exec(`INSERT INTO balance_changes VALUES (?, ?, ?, ?, ?)`,
+				change.Address, block.Height, txResult.TxId, change.Token, change.Delta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if change := FeeChange(block); change != nil {
+		_, err = sqlTx.Exec(`INSERT INTO balance_changes VALUES (?, ?, ?, ?, ?)`,
+			change.Address, block.Height, "", change.Token, change.Delta)
+		if err != nil {
+			return err
+		}
+	}
+	for i, evtResult := range body.EventResults {
+		_, err = sqlTx.Exec(`INSERT OR REPLACE INTO events VALUES (?, ?, ?, ?, ?)`,
+			evtResult.EventId, block.Height, i, evtResult.Success, evtResult.Reason)
+		if err != nil {
+			return err
+		}
+	}
+	return sqlTx.Commit()
+}
+
+// 交易对账户余额的修改,和Block.NewTransaction中的计算保持一致
+func BalanceChanges(tx *common.Transaction, txResult common.TxResult) []BalanceChange {
+	if !txResult.Success {
+		return nil
+	}
+	if action, err := tx.ElectionAction(); err == nil {
+		// 投票锁定的EKT从余额中扣除,撤回时返回余额
+		delta := -tx.Amount
+		switch action.Action {
+		case common.RegisterCandidate:
+			delta = 0
+		case common.WithdrawVote:
+			delta = tx.Amount
+		}
+		return []BalanceChange{{Address: tx.From, Delta: delta - txResult.Fee}}
+	}
+	if token, err := tx.IssuedToken(); err == nil {
+		return []BalanceChange{
+			{Address: tx.From, Token: hex.EncodeToString(token.Address()), Delta: token.Total},
+			{Address: tx.From, Delta: -txResult.Fee},
+		}
+	}
+	return []BalanceChange{
+		{Address: tx.From, Token: tx.TokenAddress, Delta: -tx.Amount},
+		{Address: tx.To, Token: tx.TokenAddress, Delta: tx.Amount},
+		{Address: tx.From, Delta: -txResult.Fee},
+	}
+}
+
+// 区块的手续费转给打包节点的账户,和Block.PayFee中的计算保持一致
+func FeeChange(block *blockchain.Block) *BalanceChange {
+	round := block.GetRound()
+	if block.TotalFee == 0 || round == nil || round.CurrentIndex < 0 || round.CurrentIndex >= len(round.Peers) {
+		return nil
+	}
+	address, err := hex.DecodeString(round.Peers[round.CurrentIndex].AccountAddress)
+	if err != nil || len(address) == 0 {
+		return nil
+	}
+	return &BalanceChange{Address: hex.EncodeToString(address), Delta: block.TotalFee}
+}
+
+// 按高度倒序返回和address相关的交易,page从1开始
+func (indexer *Indexer) TxsByAddress(address string, page, size int) ([]TxRecord, error) {
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 || size > MaxPageSize {
+		size = DefaultPageSize
+	}
+	rows, err := indexer.DB.DB.Query(`SELECT tx_id, height, from_address, to_address, amount, token, fee, nonce, timestamp, success, fail_msg
+		FROM transactions WHERE from_address = ? OR to_address = ? ORDER BY height DESC, position DESC LIMIT ? OFFSET ?`,
+		address, address, size, (page-1)*size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]TxRecord, 0)
+	for rows.Next() {
+		var record TxRecord
+		err = rows.Scan(&record.TxId, &record.Height, &record.From, &record.To, &record.Amount, &record.Token,
+			&record.Fee, &record.Nonce, &record.Timestamp, &record.Success, &record.FailMsg)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// 返回from到to之间(包括from和to)的区块,最多返回MaxBlockRange个
+func (indexer *Indexer) BlockRange(from, to int64) ([]BlockRecord, error) {
+	if to-from >= MaxBlockRange {
+		to = from + MaxBlockRange - 1
+	}
+	rows, err := indexer.DB.DB.Query(`SELECT height, hash, previous_hash, timestamp, fee, tx_count, event_count
+		FROM blocks WHERE height >= ? AND height <= ? ORDER BY height`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]BlockRecord, 0)
+	for rows.Next() {
+		var record BlockRecord
+		err = rows.Scan(&record.Height, &record.Hash, &record.PreviousHash, &record.Timestamp, &record.Fee, &record.TxCount, &record.EventCount)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (indexer *Indexer) blockBody(block *blockchain.Block) (*blockchain.BlockBody, error) {
+	if block.BlockBody != nil {
+		return block.BlockBody, nil
+	}
+	if len(block.Body) == 0 {
+		return blockchain.NewBlockBody(block.Height), nil
+	}
+	data, err := indexer.Chain.DB.Get(block.Body)
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.FromBytes(data)
+}
+
+func (indexer *Indexer) transaction(txId string) (*common.Transaction, error) {
+	id, err := hex.DecodeString(txId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := indexer.Chain.DB.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	tx := common.FromBytes(data)
+	if tx == nil {
+		return nil, errors.New("Invalid transaction")
+	}
+	return tx, nil
+}
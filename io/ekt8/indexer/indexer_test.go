@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
+	"github.com/EducationEKT/EKT/io/ekt8/core/common"
+	"github.com/EducationEKT/EKT/io/ekt8/crypto"
+	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/i_consensus"
+)
+
+func TestIndexer(t *testing.T) {
+	defer os.Remove("testIndexer.db")
+	store := db.NewMemoryDB()
+	chain := blockchain.NewBlockChain(store, blockchain.BackboneChainId, blockchain.BackboneConsensus, blockchain.BackboneChainFee, blockchain.BackboneChainDifficulty, blockchain.BackboneBlockInterval)
+	block := &blockchain.Block{
+		Height:    1,
+		StatTree:  MPTPlus.NewMTP(store),
+		TxTree:    MPTPlus.NewMTP(store),
+		EventTree: MPTPlus.NewMTP(store),
+		Round:     &i_consensus.Round{CurrentIndex: 0},
+		BlockBody: blockchain.NewBlockBody(1),
+	}
+	txs := []*common.Transaction{
+		{From: "aa", To: "bb", Amount: 100, Nonce: 1},
+		{From: "bb", To: "cc", Amount: 50, Nonce: 1, TokenAddress: "token"},
+		{From: "cc", To: "aa", Amount: 10, Nonce: 1},
+	}
+	for i, tx := range txs {
+		txId, _ := hex.DecodeString(tx.TransactionId())
+		store.Set(txId, tx.Bytes())
+		block.BlockBody.AddTxResult(*common.NewTransactionResult(tx, 10, i != 2, ""))
+	}
+	bodyData := block.BlockBody.Bytes()
+	block.Body = crypto.Sha3_256(bodyData)
+	store.Set(block.Body, bodyData)
+	block.UpdateMPTPlusRoot()
+	block.CaculateHash()
+	if err := chain.SaveBlock(block, nil); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+
+	sqlite, err := db.NewSqlite("testIndexer.db")
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	defer sqlite.DB.Close()
+	indexer, err := NewIndexer(sqlite, chain)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if err = indexer.CatchUp(); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	records, err := indexer.TxsByAddress("aa", 1, 10)
+	if err != nil || len(records) != 2 || records[0].From != "cc" || records[0].Success || records[1].Amount != 100 {
+		fmt.Println(records, err)
+		t.Fail()
+	}
+	if records, _ = indexer.TxsByAddress("aa", 2, 1); len(records) != 1 || records[0].To != "bb" {
+		fmt.Println(records)
+		t.Fail()
+	}
+	blocks, err := indexer.BlockRange(1, 10)
+	if err != nil || len(blocks) != 1 || blocks[0].TxCount != 3 || blocks[0].Hash != hex.EncodeToString(block.Hash()) {
+		fmt.Println(blocks, err)
+		t.Fail()
+	}
+	var delta int64
+	sqlite.DB.QueryRow(`SELECT SUM(delta) FROM balance_changes WHERE address = ? AND token = ''`, "bb").Scan(&delta)
+	if delta != 90 {
+		fmt.Println("unexpected balance change", delta)
+		t.Fail()
+	}
+	fmt.Println("success")
+}
@@ -2,21 +2,44 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 
+	"github.com/EducationEKT/EKT/io/ekt8/MPTPlus"
 	_ "github.com/EducationEKT/EKT/io/ekt8/api"
+	"github.com/EducationEKT/EKT/io/ekt8/blockchain"
 	"github.com/EducationEKT/EKT/io/ekt8/blockchain_manager"
 	"github.com/EducationEKT/EKT/io/ekt8/conf"
 	"github.com/EducationEKT/EKT/io/ekt8/crypto"
 	"github.com/EducationEKT/EKT/io/ekt8/db"
+	"github.com/EducationEKT/EKT/io/ekt8/indexer"
 	"github.com/EducationEKT/EKT/io/ekt8/log"
 	"github.com/EducationEKT/EKT/io/ekt8/param"
 	"github.com/EducationEKT/xserver/x_http"
 )
 
+// 离线命令,用法: main <command> [conf] [args...]
+var commands = map[string]func(args []string) error{
+	"prune":           prune,
+	"export-snapshot": exportSnapshot,
+	"import-snapshot": importSnapshot,
+	"fsck":            fsck,
+	"backup":          backup,
+	"restore":         restore,
+}
+
 func init() {
+	if len(os.Args) > 1 {
+		if _, exist := commands[os.Args[1]]; exist {
+			return
+		}
+	}
 	err := InitService()
 	if err != nil {
 		fmt.Printf("Init service failed, %v \n", err)
@@ -26,6 +49,15 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, exist := commands[os.Args[1]]; exist {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Printf("%s failed, %v \n", os.Args[1], err)
+				os.Exit(-1)
+			}
+			return
+		}
+	}
 	fmt.Printf("server listen on :%d \n", conf.EKTConfig.Node.Port)
 	err := http.ListenAndServe(fmt.Sprintf(":%d", conf.EKTConfig.Node.Port), nil)
 	if err != nil {
@@ -34,7 +66,7 @@ func main() {
 }
 
 func InitService() error {
-	err := initConfig()
+	err := initConfig(os.Args[1:])
 	if err != nil {
 		return err
 	}
@@ -52,13 +84,17 @@ func InitService() error {
 		return err
 	}
 	param.InitBootNodes()
-	blockchain_manager.Init()
-
-	return nil
+	if err = blockchain_manager.Init(); err != nil {
+		return err
+	}
+	if conf.EKTConfig.Indexer != "" {
+		err = indexer.Init(conf.EKTConfig.Indexer, blockchain_manager.GetMainChain())
+	}
+	return err
 }
 
 func initPeerId() error {
-	peerInfoKey := []byte("peerIdInfo")
+	peerInfoKey := db.NodeNamespace.Key([]byte("privateKey"))
 	v, err := db.GetDBInst().Get(peerInfoKey)
 	if err != nil || nil == v || 0 == len(v) {
 		pub, priv := crypto.GenerateKeyPair()
@@ -82,22 +118,198 @@ func initPeerId() error {
 	return nil
 }
 
-func initConfig() error {
+func initConfig(args []string) error {
 	var confPath string
-	if len(os.Args) < 2 {
+	if len(args) < 1 {
 		confPath = "genesis.json"
 		fmt.Println("No conf file specified, genesis.json will be default one.")
 	} else {
-		confPath = os.Args[1]
+		confPath = args[0]
 	}
-	err := conf.InitConfig(confPath)
-	return err
+	return conf.InitConfig(confPath)
 }
 
+// 打开数据库并升级到当前的schema版本,检查树的编码和数据库中的链是否一致
 func initDB() error {
-	return db.InitEKTDB(conf.EKTConfig.DBPath)
+	err := db.InitEKTDB(conf.EKTConfig.DBPath)
+	if err != nil {
+		return err
+	}
+	applied, err := db.Migrate(db.GetDBInst())
+	if applied > 0 {
+		fmt.Printf("Database migrated to schema version %d. \n", db.SchemaVersion)
+	}
+	if err != nil {
+		return err
+	}
+	return MPTPlus.InitEncoding(db.GetDBInst(), conf.EKTConfig.TrieEncoding)
 }
 
 func initLog() error {
 	return log.InitLog()
 }
+
+// 离线命令只读取配置文件和打开数据库,不启动共识和p2p
+func initOffline(args []string) error {
+	err := initConfig(args)
+	if err != nil {
+		return err
+	}
+	err = initDB()
+	if err != nil {
+		return err
+	}
+	return initLog()
+}
+
+// main prune [conf] [retain]
+func prune(args []string) error {
+	err := initOffline(args)
+	if err != nil {
+		return err
+	}
+	retain := conf.EKTConfig.Prune.Retain
+	if len(args) > 1 {
+		retain, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+	chain, err := blockchain_manager.LoadMainChain()
+	if err != nil {
+		return err
+	}
+	count, err := chain.Prune(retain, conf.EKTConfig.Prune.Checkpoints)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d trie nodes, current height is %d. \n", count, chain.GetLastHeight())
+	return nil
+}
+
+// main export-snapshot <conf> <height> <file>
+func exportSnapshot(args []string) error {
+	if len(args) < 3 {
+		return errors.New("Usage: export-snapshot <conf> <height> <file>")
+	}
+	err := initOffline(args)
+	if err != nil {
+		return err
+	}
+	height, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain_manager.LoadMainChain()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(args[2])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = chain.ExportSnapshot(file, height)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot at height %d exported to %s. \n", height, args[2])
+	return nil
+}
+
+// main import-snapshot <conf> <file>
+func importSnapshot(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: import-snapshot <conf> <file>")
+	}
+	err := initOffline(args)
+	if err != nil {
+		return err
+	}
+	chain := blockchain_manager.NewMainChain()
+	if _, err = chain.LastBlock(); err == nil {
+		return errors.New("Database is not empty")
+	}
+	block, err := chain.ImportSnapshotFile(args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot imported, current height is %d. \n", block.Height)
+	return nil
+}
+
+// main fsck [conf]
+func fsck(args []string) error {
+	err := initOffline(args)
+	if err != nil {
+		return err
+	}
+	chain := blockchain_manager.NewMainChain()
+	count := chain.Fsck(func(err blockchain.FsckError) {
+		fmt.Println(err.Error())
+	})
+	if count > 0 {
+		return fmt.Errorf("%d errors found", count)
+	}
+	fmt.Println("No error found.")
+	return nil
+}
+
+// main backup <conf> <file>,通过正在运行的节点的管理接口备份,备份文件写在节点所在的机器上
+func backup(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: backup <conf> <file>")
+	}
+	err := initConfig(args)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(args[1])
+	if err != nil {
+		return err
+	}
+	query := url.Values{"pwd": {conf.EKTConfig.BlockchainManagePwd}, "path": {path}}
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/admin/api/backup?%s", conf.EKTConfig.Node.Port, query.Encode()), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Status int                        `json:"status"`
+		Msg    string                     `json:"msg"`
+		Result *blockchain.BackupManifest `json:"result"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Status != 0 || result.Result == nil {
+		return errors.New(result.Msg)
+	}
+	fmt.Printf("Backup at height %d written to %s. \n", result.Result.Height, path)
+	return nil
+}
+
+// main restore <conf> <file>,节点停止并且数据库为空时恢复备份,恢复之后再启动节点
+func restore(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: restore <conf> <file>")
+	}
+	err := initConfig(args)
+	if err != nil {
+		return err
+	}
+	if err = db.InitEKTDB(conf.EKTConfig.DBPath); err != nil {
+		return err
+	}
+	file, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	manifest, err := blockchain.RestoreBackup(db.GetDBInst(), file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup restored, current height is %d, current block is %s. \n", manifest.Height, hex.EncodeToString(manifest.BlockHash))
+	return nil
+}
@@ -4,5 +4,5 @@ import (
 	"github.com/EducationEKT/EKT/io/ekt8/p2p"
 )
 
-// MainNet后面将通过testnet投票产生
+// MainNet是第一轮出块的节点,之后每一轮的节点由链上的选举交易产生,见blockchain.CurrentRound
 var MainNet = []p2p.Peer{}